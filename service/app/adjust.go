@@ -0,0 +1,59 @@
+package app
+
+import (
+	"github.com/disintegration/gift"
+	"image"
+)
+
+// adjustOptions holds tonal adjustments for separating ink from paper.
+type adjustOptions struct {
+	// LevelsBlack is the input black point from 0 to 1. Values below are mapped to
+	// black.
+	LevelsBlack float64
+	// LevelsWhite is the input white point from 0 to 1. Values above are mapped to
+	// white.
+	LevelsWhite float64
+	// Gamma correction where 1 is neutral. Values less than 1 darken midtones.
+	Gamma float64
+	// Brightness in percent from -100 to 100.
+	Brightness float64
+	// Contrast in percent from -100 to 100.
+	Contrast float64
+}
+
+// isNeutral checks whether the adjustOptions do not change the image.
+func (options adjustOptions) isNeutral() bool {
+	return options.LevelsBlack == 0 && options.LevelsWhite == 1 && options.Gamma == 1 &&
+		options.Brightness == 0 && options.Contrast == 0
+}
+
+// adjustImage applies levels, gamma, brightness and contrast in this order.
+func adjustImage(img image.Image, options adjustOptions) image.Image {
+	if options.isNeutral() {
+		return img
+	}
+	filters := make([]gift.Filter, 0)
+	if options.LevelsBlack != 0 || options.LevelsWhite != 1 {
+		black := float32(options.LevelsBlack)
+		scale := 1 / float32(options.LevelsWhite-options.LevelsBlack)
+		level := func(v float32) float32 {
+			return min(max((v-black)*scale, 0), 1)
+		}
+		filters = append(filters, gift.ColorFunc(func(r0, g0, b0, a0 float32) (r, g, b, a float32) {
+			return level(r0), level(g0), level(b0), a0
+		}))
+	}
+	if options.Gamma != 1 {
+		filters = append(filters, gift.Gamma(float32(options.Gamma)))
+	}
+	if options.Brightness != 0 {
+		filters = append(filters, gift.Brightness(float32(options.Brightness)))
+	}
+	if options.Contrast != 0 {
+		filters = append(filters, gift.Contrast(float32(options.Contrast)))
+	}
+	g := gift.New(filters...)
+	dst := image.NewRGBA(g.Bounds(img.Bounds()))
+	g.Draw(dst, img)
+	return dst
+}
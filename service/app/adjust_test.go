@@ -0,0 +1,74 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_adjustImage(t *testing.T) {
+	input := []uint8{0, 30, 64, 100, 128, 200, 240, 255}
+	img := image.NewGray(image.Rect(0, 0, len(input), 1))
+	copy(img.Pix, input)
+	neutral := adjustOptions{LevelsBlack: 0, LevelsWhite: 1, Gamma: 1, Brightness: 0, Contrast: 0}
+
+	tests := []struct {
+		name   string
+		adjust func(options *adjustOptions)
+		want   []uint8
+	}{
+		{
+			name:   "levels",
+			adjust: func(options *adjustOptions) { options.LevelsBlack, options.LevelsWhite = .2, .8 },
+			want:   []uint8{0, 0, 22, 82, 128, 248, 255, 255},
+		},
+		{
+			name:   "gamma brightens",
+			adjust: func(options *adjustOptions) { options.Gamma = 2 },
+			want:   []uint8{0, 87, 128, 160, 181, 226, 247, 255},
+		},
+		{
+			name:   "gamma darkens",
+			adjust: func(options *adjustOptions) { options.Gamma = .5 },
+			want:   []uint8{0, 4, 16, 39, 64, 157, 226, 255},
+		},
+		{
+			name:   "brightness clamps at white",
+			adjust: func(options *adjustOptions) { options.Brightness = 20 },
+			want:   []uint8{51, 81, 115, 151, 179, 251, 255, 255},
+		},
+		{
+			name:   "brightness clamps at black",
+			adjust: func(options *adjustOptions) { options.Brightness = -20 },
+			want:   []uint8{0, 0, 13, 49, 77, 149, 189, 204},
+		},
+		{
+			name:   "contrast increase clamps",
+			adjust: func(options *adjustOptions) { options.Contrast = 50 },
+			want:   []uint8{0, 0, 0, 72, 128, 255, 255, 255},
+		},
+		{
+			name:   "contrast decrease",
+			adjust: func(options *adjustOptions) { options.Contrast = -50 },
+			want:   []uint8{64, 79, 96, 114, 128, 164, 184, 191},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := neutral
+			tt.adjust(&options)
+			assert.False(t, options.isNeutral())
+			got := adjustImage(img, options)
+			for x, want := range tt.want {
+				gray := color.GrayModel.Convert(got.At(x, 0)).(color.Gray).Y
+				assert.InDelta(t, want, gray, 1, "pixel %d with input %d", x, input[x])
+			}
+		})
+	}
+
+	t.Run("neutral keeps image", func(t *testing.T) {
+		assert.True(t, neutral.isNeutral())
+		assert.Same(t, img, adjustImage(img, neutral))
+	})
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+const (
+	// alphaModeComposite blends partially transparent pixels over the
+	// transparency replacement color.
+	alphaModeComposite = "composite"
+	// alphaModeMask traces the alpha channel itself. Opaque pixels become black
+	// and transparent ones white, regardless of their color.
+	alphaModeMask = "mask"
+)
+
+var allowedAlphaModes = []string{alphaModeComposite, alphaModeMask}
+
+// compositeOverColor blends each pixel of the given image over the opaque
+// background color.
+func compositeOverColor(img image.Image, background color.RGBA) *image.RGBA {
+	bg := [3]uint32{uint32(background.R), uint32(background.G), uint32(background.B)}
+	// Values are alpha-premultiplied.
+	src := toRGBA(img)
+	dst := image.NewRGBA(src.Bounds())
+	width := src.Bounds().Dx()
+	parallelRows(src.Bounds().Dy(), func(minY, maxY int) {
+		for y := minY; y < maxY; y++ {
+			srcRow := src.Pix[y*src.Stride : y*src.Stride+4*width]
+			dstRow := dst.Pix[y*dst.Stride : y*dst.Stride+4*width]
+			for i := 0; i < len(srcRow); i += 4 {
+				remaining := math.MaxUint8 - uint32(srcRow[i+3])
+				for channel := 0; channel < 3; channel++ {
+					dstRow[i+channel] = uint8(uint32(srcRow[i+channel]) + remaining*bg[channel]/math.MaxUint8)
+				}
+				dstRow[i+3] = math.MaxUint8
+			}
+		}
+	})
+	return dst
+}
+
+// alphaMask creates an opaque grayscale image from the alpha channel of the
+// given image. Fully opaque pixels become black and fully transparent ones
+// white.
+func alphaMask(img image.Image) *image.RGBA {
+	src := toRGBA(img)
+	dst := image.NewRGBA(src.Bounds())
+	width := src.Bounds().Dx()
+	parallelRows(src.Bounds().Dy(), func(minY, maxY int) {
+		for y := minY; y < maxY; y++ {
+			srcRow := src.Pix[y*src.Stride : y*src.Stride+4*width]
+			dstRow := dst.Pix[y*dst.Stride : y*dst.Stride+4*width]
+			for i := 0; i < len(srcRow); i += 4 {
+				v := math.MaxUint8 - srcRow[i+3]
+				dstRow[i], dstRow[i+1], dstRow[i+2], dstRow[i+3] = v, v, v, math.MaxUint8
+			}
+		}
+	})
+	return dst
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_compositeOverColor(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 0, G: 0, B: 0, A: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 0, G: 0, B: 0, A: 128})
+	img.SetNRGBA(2, 0, color.NRGBA{R: 0, G: 0, B: 0, A: 0})
+
+	composited := compositeOverColor(img, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+
+	assert.Equal(t, color.RGBA{R: 0, G: 0, B: 0, A: 255}, composited.RGBAAt(0, 0))
+	assert.Equal(t, color.RGBA{R: 127, G: 127, B: 127, A: 255}, composited.RGBAAt(1, 0))
+	assert.Equal(t, color.RGBA{R: 255, G: 255, B: 255, A: 255}, composited.RGBAAt(2, 0))
+}
+
+func Test_alphaMask(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 0, G: 0, B: 0, A: 0})
+
+	mask := alphaMask(img)
+
+	assert.Equal(t, color.RGBA{R: 0, G: 0, B: 0, A: 255}, mask.RGBAAt(0, 0))
+	assert.Equal(t, color.RGBA{R: 255, G: 255, B: 255, A: 255}, mask.RGBAAt(1, 0))
+}
+
+func BenchmarkCompositeOverColor(b *testing.B) {
+	img := benchmarkImage(2000, 1500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compositeOverColor(img, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	}
+}
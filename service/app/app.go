@@ -9,19 +9,44 @@ import (
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 	"net/http"
+	"sync"
 	"time"
 )
 
 type Config struct {
 	Logger            *zap.Logger
 	HTTPAPIListenAddr string
-	PotraceFilename   string
+	// PotraceFilename is the path to the potrace binary. If empty, outlines are
+	// traced with the built-in tracer.
+	PotraceFilename string
+	// MaxImageWidth is the maximum width in pixels of uploaded images. Zero
+	// disables the limit.
+	MaxImageWidth int
+	// MaxImageHeight is the maximum height in pixels of uploaded images. Zero
+	// disables the limit.
+	MaxImageHeight int
+	// MaxImagePixels is the maximum number of pixels of uploaded images. Zero
+	// disables the limit.
+	MaxImagePixels int
+	// MaxConcurrentTraces is the maximum number of trace requests that are
+	// processed at the same time. Values below one are treated as one.
+	MaxConcurrentTraces int
+	// MaxQueuedTraces is the maximum number of trace requests that wait for being
+	// processed. Further requests are rejected.
+	MaxQueuedTraces int
 }
 
 type App struct {
 	logger     *zap.Logger
 	config     Config
 	httpClient *http.Client
+	// tracers holds the available tracers by their backend name.
+	tracers map[string]tracer
+	// readinessMutex locks notReadyReason.
+	readinessMutex sync.RWMutex
+	// notReadyReason is empty once the self-test passed.
+	notReadyReason string
+	traceQueue     *traceQueue
 }
 
 func New(config Config) *App {
@@ -29,13 +54,31 @@ func New(config Config) *App {
 		logger:     config.Logger,
 		config:     config,
 		httpClient: &http.Client{},
+		tracers:    newTracers(config),
+		// Not ready until the self-test passed.
+		notReadyReason: "self-test pending",
+		traceQueue:     newTraceQueue(config.MaxConcurrentTraces, config.MaxQueuedTraces),
 	}
 }
 
 func (app *App) Run(ctx context.Context) error {
-	app.logger.Info("startup")
+	app.logger.Info("startup",
+		zap.Strings("trace_backends", app.traceBackends()),
+		zap.Int("max_concurrent_traces", app.traceQueue.maxRunning),
+		zap.Int("max_queued_traces", app.traceQueue.maxWaiting))
 	defer app.logger.Info("shutdown")
 
+	// Fail early if potrace is configured but unusable.
+	if app.config.PotraceFilename != "" {
+		version, err := potraceTracer{filename: app.config.PotraceFilename}.version(ctx)
+		if err != nil {
+			return meh.Wrap(err, "check potrace", meh.Details{"potrace_filename": app.config.PotraceFilename})
+		}
+		app.logger.Info("found potrace",
+			zap.String("version", version.String()),
+			zap.String("potrace_filename", app.config.PotraceFilename))
+	}
+
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
 
@@ -50,6 +93,7 @@ func (app *App) Run(ctx context.Context) error {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "*")
 		c.Header("Access-Control-Allow-Headers", "*")
+		c.Header("Access-Control-Expose-Headers", "*")
 		c.Next()
 	})
 	r.Use(func(c *gin.Context) {
@@ -62,9 +106,14 @@ func (app *App) Run(ctx context.Context) error {
 	r.Use(web.RequestDebugLogger(apiLogger))
 	builder := web.HandlerBuilder{Logger: apiLogger}
 	r.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
-	r.GET("/readyz", func(c *gin.Context) { c.Status(http.StatusOK) })
-	r.POST("/api/v1/png-to-ma3-scribble/preview", builder.GinHandler(app.handlePNGToMA3Scribble(true)))
-	r.POST("/api/v1/png-to-ma3-scribble", builder.GinHandler(app.handlePNGToMA3Scribble(false)))
+	r.GET("/readyz", app.handleReady)
+	r.GET("/api/v1/capabilities", builder.GinHandler(app.handleCapabilities()))
+	r.POST("/api/v1/image-to-ma3-scribble/preview", builder.GinHandler(app.queued(tracePriorityLow, app.handleImageToMA3Scribble(true))))
+	r.POST("/api/v1/image-to-ma3-scribble", builder.GinHandler(app.queued(tracePriorityHigh, app.handleImageToMA3Scribble(false))))
+	r.POST("/api/v1/image-to-ma3-scribble/debug", builder.GinHandler(app.queued(tracePriorityLow, app.handleImageToMA3ScribbleDebug())))
+	// Legacy aliases from when only PNG was supported.
+	r.POST("/api/v1/png-to-ma3-scribble/preview", builder.GinHandler(app.queued(tracePriorityLow, app.handleImageToMA3Scribble(true))))
+	r.POST("/api/v1/png-to-ma3-scribble", builder.GinHandler(app.queued(tracePriorityHigh, app.handleImageToMA3Scribble(false))))
 
 	httpServer := http.Server{
 		Addr:           app.config.HTTPAPIListenAddr,
@@ -76,6 +125,10 @@ func (app *App) Run(ctx context.Context) error {
 	}
 
 	eg, egCtx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		app.runSelfTest(egCtx)
+		return nil
+	})
 	eg.Go(func() error {
 		app.logger.Info("serve http", zap.String("listen_addr", app.config.HTTPAPIListenAddr))
 		err := httpServer.ListenAndServe()
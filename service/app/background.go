@@ -0,0 +1,189 @@
+package app
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// backgroundRemovalOptions configures replacing the background of an image with
+// the transparency replacement color.
+type backgroundRemovalOptions struct {
+	// ChromaKeyColor enables chroma keying if set. Every pixel within
+	// ChromaKeyTolerance of this color is replaced.
+	ChromaKeyColor *color.RGBA
+	// ChromaKeyTolerance is the maximum color distance from 0 to 1.
+	ChromaKeyTolerance float64
+	// FloodFill enables replacing all pixels that are connected to the image
+	// border and similar in color to the border pixel they are reached from. Only
+	// border pixels similar to the median border color are used as seeds, so that
+	// ink touching the border is kept.
+	FloodFill bool
+	// FloodFillTolerance is the maximum color distance from 0 to 1.
+	FloodFillTolerance float64
+}
+
+// maxColorDistance is the Euclidean distance between black and white in RGB.
+var maxColorDistance = math.Sqrt(3 * 255 * 255)
+
+// colorDistance returns the Euclidean distance of the given colors in RGB,
+// normalized to the range 0 to 1.
+func colorDistance(a, b color.RGBA) float64 {
+	dr := float64(a.R) - float64(b.R)
+	dg := float64(a.G) - float64(b.G)
+	db := float64(a.B) - float64(b.B)
+	return math.Sqrt(dr*dr+dg*dg+db*db) / maxColorDistance
+}
+
+// removeBackground replaces background pixels of the given opaque image in
+// place with the replacement color.
+func removeBackground(img *image.RGBA, options backgroundRemovalOptions, replacement color.RGBA) {
+	if options.ChromaKeyColor != nil {
+		chromaKey(img, *options.ChromaKeyColor, options.ChromaKeyTolerance, replacement)
+	}
+	if options.FloodFill {
+		floodFillFromBorder(img, options.FloodFillTolerance, replacement)
+	}
+}
+
+// chromaKey replaces all pixels within the tolerance of the key color.
+func chromaKey(img *image.RGBA, key color.RGBA, tolerance float64, replacement color.RGBA) {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	parallelRows(bounds.Dy(), func(minY, maxY int) {
+		for y := minY; y < maxY; y++ {
+			offset := img.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			row := img.Pix[offset : offset+4*width]
+			for i := 0; i < len(row); i += 4 {
+				c := color.RGBA{R: row[i], G: row[i+1], B: row[i+2], A: row[i+3]}
+				if colorDistance(c, key) <= tolerance {
+					row[i], row[i+1], row[i+2], row[i+3] = replacement.R, replacement.G, replacement.B, replacement.A
+				}
+			}
+		}
+	})
+}
+
+// rgbaAtFunc returns a function that reads the pixel at the given coordinates
+// as color.RGBA. Pixels of *image.RGBA and *image.NRGBA are read from their Pix
+// slices without going through the image.Image interface.
+func rgbaAtFunc(img image.Image) func(x, y int) color.RGBA {
+	switch img := img.(type) {
+	case *image.RGBA:
+		return func(x, y int) color.RGBA {
+			pix := img.Pix[img.PixOffset(x, y):]
+			return color.RGBA{R: pix[0], G: pix[1], B: pix[2], A: pix[3]}
+		}
+	case *image.NRGBA:
+		return func(x, y int) color.RGBA {
+			pix := img.Pix[img.PixOffset(x, y):]
+			// Premultiply alpha exactly like color.RGBAModel does.
+			r, g, b, a := color.NRGBA{R: pix[0], G: pix[1], B: pix[2], A: pix[3]}.RGBA()
+			return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
+		}
+	default:
+		return func(x, y int) color.RGBA {
+			return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+		}
+	}
+}
+
+// medianBorderColor returns the per-channel median of all border pixels.
+func medianBorderColor(img image.Image) color.RGBA {
+	bounds := img.Bounds()
+	rgbaAt := rgbaAtFunc(img)
+	var histograms [3][256]int
+	total := 0
+	add := func(x, y int) {
+		c := rgbaAt(x, y)
+		histograms[0][c.R]++
+		histograms[1][c.G]++
+		histograms[2][c.B]++
+		total++
+	}
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		add(x, bounds.Min.Y)
+		add(x, bounds.Max.Y-1)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		add(bounds.Min.X, y)
+		add(bounds.Max.X-1, y)
+	}
+	var median [3]uint8
+	for channel, histogram := range histograms {
+		count := 0
+		for v, n := range histogram {
+			count += n
+			if count*2 >= total {
+				median[channel] = uint8(v)
+				break
+			}
+		}
+	}
+	return color.RGBA{R: median[0], G: median[1], B: median[2], A: 255}
+}
+
+// floodFillFromBorder replaces all pixels that are reachable from a border
+// pixel via 4-connected neighbors within the tolerance of that border pixel's
+// color. Border pixels are only used as seeds if they are within the tolerance
+// of the median border color.
+func floodFillFromBorder(img *image.RGBA, tolerance float64, replacement color.RGBA) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return
+	}
+	background := medianBorderColor(img)
+	type fillItem struct {
+		x, y int
+		seed color.RGBA
+	}
+	visited := make([]bool, width*height)
+	queue := make([]fillItem, 0)
+	seed := func(x, y int) {
+		i := y*width + x
+		if visited[i] {
+			return
+		}
+		c := img.RGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+		if colorDistance(c, background) > tolerance {
+			return
+		}
+		visited[i] = true
+		queue = append(queue, fillItem{x: x, y: y, seed: c})
+	}
+	for x := 0; x < width; x++ {
+		seed(x, 0)
+		seed(x, height-1)
+	}
+	for y := 0; y < height; y++ {
+		seed(0, y)
+		seed(width-1, y)
+	}
+	// Collect all pixels before replacing, so that comparisons use original
+	// colors.
+	filled := make([]int, 0)
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+		filled = append(filled, item.y*width+item.x)
+		for _, d := range [4][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+			nx, ny := item.x+d[0], item.y+d[1]
+			if nx < 0 || nx >= width || ny < 0 || ny >= height {
+				continue
+			}
+			i := ny*width + nx
+			if visited[i] {
+				continue
+			}
+			if colorDistance(img.RGBAAt(bounds.Min.X+nx, bounds.Min.Y+ny), item.seed) > tolerance {
+				continue
+			}
+			visited[i] = true
+			queue = append(queue, fillItem{x: nx, y: ny, seed: item.seed})
+		}
+	}
+	for _, i := range filled {
+		img.SetRGBA(bounds.Min.X+i%width, bounds.Min.Y+i/width, replacement)
+	}
+}
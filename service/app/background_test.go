@@ -0,0 +1,85 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func Test_removeBackground(t *testing.T) {
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.RGBA{A: 255}
+	green := color.RGBA{G: 200, A: 255}
+	red := color.RGBA{R: 255, A: 255}
+
+	// Green background with a black ring around a green hole and a black line
+	// touching the left border.
+	newImage := func() *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 9, 9))
+		for y := 0; y < 9; y++ {
+			for x := 0; x < 9; x++ {
+				img.SetRGBA(x, y, green)
+			}
+		}
+		for i := 3; i <= 7; i++ {
+			img.SetRGBA(i, 3, black)
+			img.SetRGBA(i, 7, black)
+			img.SetRGBA(3, i, black)
+			img.SetRGBA(7, i, black)
+		}
+		img.SetRGBA(0, 1, black)
+		img.SetRGBA(1, 1, black)
+		return img
+	}
+
+	t.Run("chroma key", func(t *testing.T) {
+		img := newImage()
+		removeBackground(img, backgroundRemovalOptions{ChromaKeyColor: &green, ChromaKeyTolerance: 0.1}, red)
+		assert.Equal(t, red, img.RGBAAt(0, 0))
+		assert.Equal(t, red, img.RGBAAt(5, 5), "enclosed pixels should be keyed")
+		assert.Equal(t, black, img.RGBAAt(3, 3))
+		assert.Equal(t, black, img.RGBAAt(0, 1))
+	})
+
+	t.Run("flood fill", func(t *testing.T) {
+		img := newImage()
+		removeBackground(img, backgroundRemovalOptions{FloodFill: true, FloodFillTolerance: 0.1}, white)
+		assert.Equal(t, white, img.RGBAAt(0, 0))
+		assert.Equal(t, white, img.RGBAAt(8, 8))
+		assert.Equal(t, green, img.RGBAAt(5, 5), "enclosed pixels should not be filled")
+		assert.Equal(t, black, img.RGBAAt(3, 3))
+		assert.Equal(t, black, img.RGBAAt(0, 1), "ink touching the border should be kept")
+	})
+}
+
+func Test_rgbaAtFunc(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	bounds := image.Rect(-2, 3, 5, 9)
+	rgba := image.NewRGBA(bounds)
+	nrgba := image.NewNRGBA(bounds)
+	rng.Read(rgba.Pix)
+	rng.Read(nrgba.Pix)
+	gray := image.NewGray(bounds)
+	rng.Read(gray.Pix)
+
+	for _, img := range []image.Image{rgba, nrgba, gray, rgba.SubImage(image.Rect(0, 4, 3, 8))} {
+		rgbaAt := rgbaAtFunc(img)
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				require.Equal(t, color.RGBAModel.Convert(img.At(x, y)), rgbaAt(x, y), "%T at (%d,%d)", img, x, y)
+			}
+		}
+	}
+}
+
+func BenchmarkMedianBorderColor(b *testing.B) {
+	img := benchmarkImage(2000, 1500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		medianBorderColor(img)
+	}
+}
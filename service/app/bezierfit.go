@@ -0,0 +1,246 @@
+package app
+
+import (
+	"math"
+	"slices"
+)
+
+// point is a 2D point or vector.
+type point struct {
+	X, Y float64
+}
+
+func (p point) add(o point) point        { return point{X: p.X + o.X, Y: p.Y + o.Y} }
+func (p point) sub(o point) point        { return point{X: p.X - o.X, Y: p.Y - o.Y} }
+func (p point) scale(f float64) point    { return point{X: p.X * f, Y: p.Y * f} }
+func (p point) dot(o point) float64      { return p.X*o.X + p.Y*o.Y }
+func (p point) length() float64          { return math.Hypot(p.X, p.Y) }
+func (p point) distance(o point) float64 { return p.sub(o).length() }
+
+// normalize returns the unit vector for p or the zero vector if p has no
+// length.
+func (p point) normalize() point {
+	l := p.length()
+	if l == 0 {
+		return point{}
+	}
+	return p.scale(1 / l)
+}
+
+// cubicBezier is a cubic Bézier curve with start point P0, control points P1
+// and P2 and end point P3.
+type cubicBezier struct {
+	P0, P1, P2, P3 point
+}
+
+// at evaluates the curve at t from 0 to 1.
+func (b cubicBezier) at(t float64) point {
+	mt := 1 - t
+	return b.P0.scale(mt * mt * mt).
+		add(b.P1.scale(3 * mt * mt * t)).
+		add(b.P2.scale(3 * mt * t * t)).
+		add(b.P3.scale(t * t * t))
+}
+
+// derivative evaluates the first derivative of the curve at t.
+func (b cubicBezier) derivative(t float64) point {
+	mt := 1 - t
+	return b.P1.sub(b.P0).scale(3 * mt * mt).
+		add(b.P2.sub(b.P1).scale(6 * mt * t)).
+		add(b.P3.sub(b.P2).scale(3 * t * t))
+}
+
+// secondDerivative evaluates the second derivative of the curve at t.
+func (b cubicBezier) secondDerivative(t float64) point {
+	return b.P2.sub(b.P1.scale(2)).add(b.P0).scale(6 * (1 - t)).
+		add(b.P3.sub(b.P2.scale(2)).add(b.P1).scale(6 * t))
+}
+
+// extremaParams returns the parameters t within (0, 1) where the derivative of
+// the curve along one axis with the given coordinates is zero.
+func extremaParams(p0, p1, p2, p3 float64) []float64 {
+	// The derivative divided by 3 is a*t^2 + b*t + c.
+	a := -p0 + 3*p1 - 3*p2 + p3
+	b := 2 * (p0 - 2*p1 + p2)
+	c := p1 - p0
+	roots := make([]float64, 0, 2)
+	const epsilon = 1e-12
+	if math.Abs(a) < epsilon {
+		if math.Abs(b) >= epsilon {
+			roots = append(roots, -c/b)
+		}
+	} else if discriminant := b*b - 4*a*c; discriminant >= 0 {
+		sqrt := math.Sqrt(discriminant)
+		roots = append(roots, (-b+sqrt)/(2*a), (-b-sqrt)/(2*a))
+	}
+	return slices.DeleteFunc(roots, func(t float64) bool { return t <= 0 || t >= 1 })
+}
+
+// bounds returns the tight bounding box of the curve. Unlike the bounding box
+// of all four points, it does not include control points that lie outside the
+// curve.
+func (b cubicBezier) bounds() (minPoint, maxPoint point) {
+	minPoint = point{X: min(b.P0.X, b.P3.X), Y: min(b.P0.Y, b.P3.Y)}
+	maxPoint = point{X: max(b.P0.X, b.P3.X), Y: max(b.P0.Y, b.P3.Y)}
+	for _, t := range extremaParams(b.P0.X, b.P1.X, b.P2.X, b.P3.X) {
+		x := b.at(t).X
+		minPoint.X, maxPoint.X = min(minPoint.X, x), max(maxPoint.X, x)
+	}
+	for _, t := range extremaParams(b.P0.Y, b.P1.Y, b.P2.Y, b.P3.Y) {
+		y := b.at(t).Y
+		minPoint.Y, maxPoint.Y = min(minPoint.Y, y), max(maxPoint.Y, y)
+	}
+	return minPoint, maxPoint
+}
+
+// bezierFitMaxReparameterizations is the number of Newton-Raphson iterations
+// for improving the parameterization before splitting a curve.
+const bezierFitMaxReparameterizations = 4
+
+// fitCubicBeziers fits a sequence of cubic Bézier curves to the given polyline
+// so that no point deviates more than maxError from the curves. It implements
+// the algorithm by Philip J. Schneider from Graphics Gems.
+func fitCubicBeziers(points []point, maxError float64) []cubicBezier {
+	// Remove consecutive duplicates as they break tangent estimation.
+	deduplicated := make([]point, 0, len(points))
+	for _, p := range points {
+		if len(deduplicated) == 0 || deduplicated[len(deduplicated)-1] != p {
+			deduplicated = append(deduplicated, p)
+		}
+	}
+	points = deduplicated
+	if len(points) < 2 {
+		return nil
+	}
+	leftTangent := points[1].sub(points[0]).normalize()
+	rightTangent := points[len(points)-2].sub(points[len(points)-1]).normalize()
+	return fitCubicBezierRange(points, leftTangent, rightTangent, maxError)
+}
+
+// fitCubicBezierRange fits curves to the given points with the given unit
+// tangents at both ends.
+func fitCubicBezierRange(points []point, leftTangent, rightTangent point, maxError float64) []cubicBezier {
+	if len(points) == 2 {
+		d := points[0].distance(points[1]) / 3
+		return []cubicBezier{{
+			P0: points[0],
+			P1: points[0].add(leftTangent.scale(d)),
+			P2: points[1].add(rightTangent.scale(d)),
+			P3: points[1],
+		}}
+	}
+
+	u := chordLengthParameterize(points)
+	bezier := generateBezier(points, u, leftTangent, rightTangent)
+	worstError, splitPoint := maxBezierError(points, bezier, u)
+	if worstError <= maxError*maxError {
+		return []cubicBezier{bezier}
+	}
+	// Try improving the parameterization if the error is not too large.
+	if worstError <= 4*maxError*maxError {
+		for range bezierFitMaxReparameterizations {
+			u = reparameterize(bezier, points, u)
+			bezier = generateBezier(points, u, leftTangent, rightTangent)
+			worstError, splitPoint = maxBezierError(points, bezier, u)
+			if worstError <= maxError*maxError {
+				return []cubicBezier{bezier}
+			}
+		}
+	}
+
+	// Split at the point of the worst error and fit both halves.
+	centerTangent := points[splitPoint-1].sub(points[splitPoint+1]).normalize()
+	if centerTangent == (point{}) {
+		centerTangent = point{X: -(points[splitPoint].Y - points[splitPoint-1].Y), Y: points[splitPoint].X - points[splitPoint-1].X}.normalize()
+	}
+	left := fitCubicBezierRange(points[:splitPoint+1], leftTangent, centerTangent, maxError)
+	right := fitCubicBezierRange(points[splitPoint:], centerTangent.scale(-1), rightTangent, maxError)
+	return append(left, right...)
+}
+
+// chordLengthParameterize assigns parameter values from 0 to 1 to the points
+// based on the relative distance along the polyline.
+func chordLengthParameterize(points []point) []float64 {
+	u := make([]float64, len(points))
+	for i := 1; i < len(points); i++ {
+		u[i] = u[i-1] + points[i].distance(points[i-1])
+	}
+	total := u[len(u)-1]
+	for i := range u {
+		u[i] /= total
+	}
+	return u
+}
+
+// generateBezier finds the control points via least squares for the given
+// parameterization and end tangents.
+func generateBezier(points []point, u []float64, leftTangent, rightTangent point) cubicBezier {
+	first, last := points[0], points[len(points)-1]
+	var c00, c01, c11, x0, x1 float64
+	for i, p := range points {
+		t := u[i]
+		mt := 1 - t
+		a1 := leftTangent.scale(3 * mt * mt * t)
+		a2 := rightTangent.scale(3 * mt * t * t)
+		c00 += a1.dot(a1)
+		c01 += a1.dot(a2)
+		c11 += a2.dot(a2)
+		tmp := p.sub(cubicBezier{P0: first, P1: first, P2: last, P3: last}.at(t))
+		x0 += a1.dot(tmp)
+		x1 += a2.dot(tmp)
+	}
+	det := c00*c11 - c01*c01
+	alphaLeft, alphaRight := 0.0, 0.0
+	if det != 0 {
+		alphaLeft = (x0*c11 - x1*c01) / det
+		alphaRight = (c00*x1 - c01*x0) / det
+	}
+	// Fall back to a heuristic if the solution is degenerate.
+	segmentLength := first.distance(last)
+	epsilon := 1e-6 * segmentLength
+	if alphaLeft < epsilon || alphaRight < epsilon {
+		alphaLeft = segmentLength / 3
+		alphaRight = segmentLength / 3
+	}
+	return cubicBezier{
+		P0: first,
+		P1: first.add(leftTangent.scale(alphaLeft)),
+		P2: last.add(rightTangent.scale(alphaRight)),
+		P3: last,
+	}
+}
+
+// maxBezierError returns the largest squared distance of the points from the
+// curve as well as the index of the point where it occurs.
+func maxBezierError(points []point, bezier cubicBezier, u []float64) (float64, int) {
+	worst := 0.0
+	splitPoint := len(points) / 2
+	for i := 1; i < len(points)-1; i++ {
+		d := bezier.at(u[i]).sub(points[i])
+		squared := d.dot(d)
+		if squared >= worst {
+			worst = squared
+			splitPoint = i
+		}
+	}
+	return worst, splitPoint
+}
+
+// reparameterize improves the parameter values using Newton-Raphson iteration.
+func reparameterize(bezier cubicBezier, points []point, u []float64) []float64 {
+	improved := make([]float64, len(u))
+	for i, p := range points {
+		t := u[i]
+		d := bezier.at(t).sub(p)
+		d1 := bezier.derivative(t)
+		d2 := bezier.secondDerivative(t)
+		numerator := d.dot(d1)
+		denominator := d1.dot(d1) + d.dot(d2)
+		if denominator == 0 {
+			improved[i] = t
+			continue
+		}
+		improved[i] = min(max(t-numerator/denominator, 0), 1)
+	}
+	return improved
+}
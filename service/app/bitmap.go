@@ -0,0 +1,75 @@
+package app
+
+import (
+	"image"
+	"math"
+)
+
+// bitmap is a bilevel image where true means ink.
+type bitmap struct {
+	width  int
+	height int
+	pix    []bool
+}
+
+func newBitmap(width, height int) *bitmap {
+	return &bitmap{
+		width:  width,
+		height: height,
+		pix:    make([]bool, width*height),
+	}
+}
+
+// at returns whether the pixel is set. Pixels outside the bitmap are never set.
+func (bm *bitmap) at(x, y int) bool {
+	if x < 0 || x >= bm.width || y < 0 || y >= bm.height {
+		return false
+	}
+	return bm.pix[y*bm.width+x]
+}
+
+// thresholdBitmap creates a bitmap from the given image. Like potrace, pixels
+// with r+g+b <= 3*255*blackLevel are ink.
+func thresholdBitmap(img image.Image, blackLevel float64, invert bool) *bitmap {
+	src := toRGBA(img)
+	bm := newBitmap(src.Bounds().Dx(), src.Bounds().Dy())
+	limit := 3 * 255 * blackLevel
+	parallelRows(bm.height, func(minY, maxY int) {
+		for y := minY; y < maxY; y++ {
+			row := src.Pix[y*src.Stride : y*src.Stride+4*bm.width]
+			for x := 0; x < bm.width; x++ {
+				ink := float64(int(row[4*x])+int(row[4*x+1])+int(row[4*x+2])) <= limit
+				bm.pix[y*bm.width+x] = ink != invert
+			}
+		}
+	})
+	return bm
+}
+
+// isBilevel reports whether the given image only consists of black and white
+// pixels.
+func isBilevel(img image.Image) bool {
+	src := toRGBA(img)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	for y := 0; y < height; y++ {
+		row := src.Pix[y*src.Stride : y*src.Stride+4*width]
+		for x := 0; x < width; x++ {
+			r, g, b := row[4*x], row[4*x+1], row[4*x+2]
+			if r != g || g != b || (r != 0 && r != math.MaxUint8) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// gray returns the bitmap as image with black ink on white.
+func (bm *bitmap) gray() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, bm.width, bm.height))
+	for i, ink := range bm.pix {
+		if !ink {
+			img.Pix[i] = math.MaxUint8
+		}
+	}
+	return img
+}
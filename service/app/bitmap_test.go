@@ -0,0 +1,52 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_thresholdBitmap(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	img.SetRGBA(0, 0, color.RGBA{R: 0, G: 0, B: 0, A: 255})
+	// Intensity sum of 382 is just below 765 * 0.5.
+	img.SetRGBA(1, 0, color.RGBA{R: 127, G: 127, B: 128, A: 255})
+	img.SetRGBA(2, 0, color.RGBA{R: 128, G: 128, B: 128, A: 255})
+
+	t.Run("black level", func(t *testing.T) {
+		bm := thresholdBitmap(img, .5, false)
+		assert.Equal(t, []bool{true, true, false}, bm.pix)
+	})
+
+	t.Run("invert", func(t *testing.T) {
+		bm := thresholdBitmap(img, .5, true)
+		assert.Equal(t, []bool{false, false, true}, bm.pix)
+	})
+
+	t.Run("gray", func(t *testing.T) {
+		gray := thresholdBitmap(img, .5, false).gray()
+		assert.Equal(t, []uint8{0, 0, 255}, gray.Pix)
+	})
+}
+
+func Test_isBilevel(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, color.RGBA{R: 0, G: 0, B: 0, A: 255})
+	img.SetRGBA(1, 0, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	assert.True(t, isBilevel(img))
+
+	img.SetRGBA(1, 0, color.RGBA{R: 255, G: 0, B: 255, A: 255})
+	assert.False(t, isBilevel(img), "color")
+
+	img.SetRGBA(1, 0, color.RGBA{R: 128, G: 128, B: 128, A: 255})
+	assert.False(t, isBilevel(img), "gray")
+}
+
+func BenchmarkThresholdBitmap(b *testing.B) {
+	img := benchmarkImage(2000, 1500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		thresholdBitmap(img, .5, false)
+	}
+}
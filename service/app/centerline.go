@@ -0,0 +1,369 @@
+package app
+
+import (
+	"go.uber.org/zap"
+	"slices"
+	"time"
+)
+
+// neighborOffsets are the 8 neighbors of a pixel in clockwise order, starting at
+// the top. 4-neighbors have even indices.
+var neighborOffsets = [8][2]int{{0, -1}, {1, -1}, {1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}}
+
+// despeckle removes 8-connected ink components and holes with less than
+// minArea pixels. This matches the turd size semantics of potrace.
+func (bm *bitmap) despeckle(minArea int) {
+	bm.removeSmallComponents(minArea, true)
+	bm.removeSmallComponents(minArea, false)
+}
+
+// removeSmallComponents removes 8-connected ink components with less than
+// minArea pixels if ink is true. Otherwise, holes are filled.
+func (bm *bitmap) removeSmallComponents(minArea int, ink bool) {
+	visited := make([]bool, len(bm.pix))
+	component := make([]int, 0)
+	for start, set := range bm.pix {
+		if set != ink || visited[start] {
+			continue
+		}
+		// Collect the component. It doubles as the queue for the flood fill.
+		visited[start] = true
+		component = append(component[:0], start)
+		for next := 0; next < len(component); next++ {
+			x, y := component[next]%bm.width, component[next]/bm.width
+			for _, d := range neighborOffsets {
+				nx, ny := x+d[0], y+d[1]
+				if nx < 0 || nx >= bm.width || ny < 0 || ny >= bm.height {
+					continue
+				}
+				i := ny*bm.width + nx
+				if bm.pix[i] != ink || visited[i] {
+					continue
+				}
+				visited[i] = true
+				component = append(component, i)
+			}
+		}
+		if len(component) >= minArea {
+			continue
+		}
+		for _, i := range component {
+			bm.pix[i] = !ink
+		}
+	}
+}
+
+// fillPinholes sets all pixels whose 4-neighbors are all set. It reports
+// whether any pixel was changed.
+func (bm *bitmap) fillPinholes() bool {
+	filled := false
+	for y := 0; y < bm.height; y++ {
+		for x := 0; x < bm.width; x++ {
+			if bm.pix[y*bm.width+x] {
+				continue
+			}
+			if bm.at(x, y-1) && bm.at(x+1, y) && bm.at(x, y+1) && bm.at(x-1, y) {
+				bm.pix[y*bm.width+x] = true
+				filled = true
+			}
+		}
+	}
+	return filled
+}
+
+// neighbors returns the 8 neighbors of the pixel in the order of
+// neighborOffsets.
+func (bm *bitmap) neighbors(x, y int) [8]bool {
+	var n [8]bool
+	for i, d := range neighborOffsets {
+		n[i] = bm.at(x+d[0], y+d[1])
+	}
+	return n
+}
+
+// crossingNumber returns the number of transitions from unset to set pixels
+// around the given neighborhood. It is 1 for ends of lines, 2 for pixels within
+// lines and at least 3 for junctions.
+func crossingNumber(n [8]bool) int {
+	transitions := 0
+	for i := range n {
+		if !n[i] && n[(i+1)%8] {
+			transitions++
+		}
+	}
+	return transitions
+}
+
+// skeletonize thins the bitmap to 1 pixel wide lines using the Zhang-Suen
+// algorithm.
+func (bm *bitmap) skeletonize() {
+	// Pixels surrounded by ink are never removed. Only tracking the border avoids
+	// scanning the interior of thick shapes in each pass. Pixels only join the
+	// border when a neighbor is removed.
+	isCandidate := make([]bool, len(bm.pix))
+	candidates := make([]int, 0)
+	for i, set := range bm.pix {
+		if !set {
+			continue
+		}
+		n := bm.neighbors(i%bm.width, i/bm.width)
+		if slices.Contains(n[:], false) {
+			isCandidate[i] = true
+			candidates = append(candidates, i)
+		}
+	}
+	toRemove := make([]int, 0)
+	for {
+		changed := false
+		for pass := 0; pass < 2; pass++ {
+			toRemove = toRemove[:0]
+			for _, i := range candidates {
+				x, y := i%bm.width, i/bm.width
+				n := bm.neighbors(x, y)
+				count := 0
+				for _, set := range n {
+					if set {
+						count++
+					}
+				}
+				if count < 2 || count > 6 || crossingNumber(n) != 1 {
+					continue
+				}
+				// Indices: 0 = north, 2 = east, 4 = south, 6 = west.
+				if pass == 0 && ((n[0] && n[2] && n[4]) || (n[2] && n[4] && n[6])) {
+					continue
+				}
+				if pass == 1 && ((n[0] && n[2] && n[6]) || (n[0] && n[4] && n[6])) {
+					continue
+				}
+				toRemove = append(toRemove, i)
+			}
+			for _, i := range toRemove {
+				bm.pix[i] = false
+			}
+			if len(toRemove) == 0 {
+				continue
+			}
+			changed = true
+			candidates = slices.DeleteFunc(candidates, func(i int) bool { return !bm.pix[i] })
+			for _, i := range toRemove {
+				x, y := i%bm.width, i/bm.width
+				for _, d := range neighborOffsets {
+					nx, ny := x+d[0], y+d[1]
+					if !bm.at(nx, ny) || isCandidate[ny*bm.width+nx] {
+						continue
+					}
+					isCandidate[ny*bm.width+nx] = true
+					candidates = append(candidates, ny*bm.width+nx)
+				}
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	// Zhang-Suen leaves staircases where lines are 2 pixels thick in diagonal
+	// direction. Remove corner pixels whose neighbors are connected anyway.
+	for y := 0; y < bm.height; y++ {
+		for x := 0; x < bm.width; x++ {
+			if !bm.pix[y*bm.width+x] {
+				continue
+			}
+			n := bm.neighbors(x, y)
+			for k := 0; k < 8; k += 2 {
+				if n[k] && n[(k+2)%8] && !n[(k+4)%8] && !n[(k+5)%8] && !n[(k+6)%8] {
+					bm.pix[y*bm.width+x] = false
+					break
+				}
+			}
+		}
+	}
+}
+
+// centerlinePath is a polyline along a skeleton.
+type centerlinePath struct {
+	points []point
+	// hasLooseEnd is true if the path starts or ends at a line end instead of a
+	// junction. Short paths with loose ends are spurs from skeletonization.
+	hasLooseEnd bool
+	// closed is true for loops without junctions. The last point equals the first
+	// one.
+	closed bool
+}
+
+// length returns the length of the polyline.
+func (path centerlinePath) length() float64 {
+	l := 0.0
+	for i := 1; i < len(path.points); i++ {
+		l += path.points[i].distance(path.points[i-1])
+	}
+	return l
+}
+
+// followSkeleton splits the skeleton into polylines between line ends and
+// junctions. Closed loops without junctions become closed polylines.
+func (bm *bitmap) followSkeleton() []centerlinePath {
+	const (
+		kindNone = iota
+		kindEnd
+		kindLine
+		kindJunction
+	)
+	kinds := make([]int, len(bm.pix))
+	for y := 0; y < bm.height; y++ {
+		for x := 0; x < bm.width; x++ {
+			if !bm.pix[y*bm.width+x] {
+				continue
+			}
+			switch crossing := crossingNumber(bm.neighbors(x, y)); {
+			case crossing <= 1:
+				kinds[y*bm.width+x] = kindEnd
+			case crossing == 2:
+				kinds[y*bm.width+x] = kindLine
+			default:
+				kinds[y*bm.width+x] = kindJunction
+			}
+		}
+	}
+	isNode := func(i int) bool {
+		return kinds[i] == kindEnd || kinds[i] == kindJunction
+	}
+	visited := make([]bool, len(bm.pix))
+	// Direct connections between adjacent nodes are tracked separately as nodes
+	// are not marked as visited.
+	visitedNodeLinks := make(map[[2]int]bool)
+	toPoint := func(i int) point {
+		return point{X: float64(i%bm.width) + 0.5, Y: float64(i/bm.width) + 0.5}
+	}
+	// next finds the pixel to continue with from the current one. 4-neighbors are
+	// preferred for not skipping pixels in staircases.
+	next := func(current, previous int) (int, bool) {
+		x, y := current%bm.width, current/bm.width
+		for _, pass := range [2]int{0, 1} {
+			for i := pass; i < 8; i += 2 {
+				d := neighborOffsets[i]
+				if !bm.at(x+d[0], y+d[1]) {
+					continue
+				}
+				n := (y+d[1])*bm.width + x + d[0]
+				if n == previous {
+					continue
+				}
+				if isNode(n) || !visited[n] {
+					return n, true
+				}
+			}
+		}
+		return 0, false
+	}
+	walk := func(start, first int) []int {
+		pixels := []int{start, first}
+		previous, current := start, first
+		for !isNode(current) {
+			visited[current] = true
+			n, ok := next(current, previous)
+			if !ok {
+				break
+			}
+			pixels = append(pixels, n)
+			previous, current = current, n
+		}
+		return pixels
+	}
+
+	paths := make([]centerlinePath, 0)
+	for start := range bm.pix {
+		if !isNode(start) {
+			continue
+		}
+		x, y := start%bm.width, start/bm.width
+		for _, d := range neighborOffsets {
+			if !bm.at(x+d[0], y+d[1]) {
+				continue
+			}
+			first := (y+d[1])*bm.width + x + d[0]
+			if isNode(first) {
+				link := [2]int{min(start, first), max(start, first)}
+				if visitedNodeLinks[link] {
+					continue
+				}
+				visitedNodeLinks[link] = true
+			} else if visited[first] {
+				continue
+			}
+			pixels := walk(start, first)
+			path := centerlinePath{
+				points:      make([]point, 0, len(pixels)),
+				hasLooseEnd: kinds[pixels[0]] == kindEnd || kinds[pixels[len(pixels)-1]] == kindEnd,
+			}
+			for _, p := range pixels {
+				path.points = append(path.points, toPoint(p))
+			}
+			paths = append(paths, path)
+		}
+	}
+	// Remaining pixels form loops without any nodes.
+	for start := range bm.pix {
+		if kinds[start] != kindLine || visited[start] {
+			continue
+		}
+		visited[start] = true
+		pixels := []int{start}
+		previous, current := -1, start
+		for {
+			n, ok := next(current, previous)
+			if !ok || visited[n] {
+				break
+			}
+			visited[n] = true
+			pixels = append(pixels, n)
+			previous, current = current, n
+		}
+		// Close the loop.
+		pixels = append(pixels, start)
+		path := centerlinePath{points: make([]point, 0, len(pixels)), closed: true}
+		for _, p := range pixels {
+			path.points = append(path.points, toPoint(p))
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// traceCenterline traces the skeleton of the ink in the given bitmap. Each
+// stroke becomes a single path instead of an outline. Only loops without
+// junctions become closed paths. The bitmap is thinned in place.
+func traceCenterline(logger *zap.Logger, config TraceConfig, bm *bitmap) tracedGeometry {
+	start := time.Now()
+	if config.TurdSize > 0 {
+		bm.despeckle(config.TurdSize)
+	}
+	bm.skeletonize()
+	// Thinning thick areas may leave tiny loops around single pixels. Fill and thin
+	// them again.
+	if bm.fillPinholes() {
+		bm.skeletonize()
+	}
+	paths := bm.followSkeleton()
+
+	geometry := tracedGeometry{
+		Width:  bm.width,
+		Height: bm.height,
+		Paths:  make([]tracedPath, 0, len(paths)),
+	}
+	for _, path := range paths {
+		if path.hasLooseEnd && path.length() < config.CenterlineMinLength {
+			continue
+		}
+		curves := fitCubicBeziers(path.points, config.CenterlineFitTolerance)
+		if len(curves) == 0 {
+			continue
+		}
+		geometry.Paths = append(geometry.Paths, tracedPath{Curves: curves, Closed: path.closed})
+	}
+	logger.Debug("centerline traced",
+		zap.Int("path_count", len(paths)),
+		zap.Int("stroke_count", len(geometry.Paths)),
+		zap.Duration("took", time.Since(start)))
+	return geometry
+}
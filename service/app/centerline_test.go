@@ -0,0 +1,168 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"math"
+	"testing"
+)
+
+func Test_bitmapFollowSkeleton(t *testing.T) {
+	t.Run("thick line", func(t *testing.T) {
+		bm := newBitmap(60, 20)
+		for y := 8; y < 13; y++ {
+			for x := 5; x < 55; x++ {
+				bm.pix[y*bm.width+x] = true
+			}
+		}
+		bm.skeletonize()
+		paths := bm.followSkeleton()
+		require.Len(t, paths, 1)
+		assert.True(t, paths[0].hasLooseEnd)
+		assert.False(t, paths[0].closed)
+		assert.InDelta(t, 45, paths[0].length(), 5)
+		for _, p := range paths[0].points {
+			assert.InDelta(t, 10.5, p.Y, 1)
+		}
+	})
+
+	t.Run("ring", func(t *testing.T) {
+		bm := newBitmap(40, 40)
+		for y := 0; y < bm.height; y++ {
+			for x := 0; x < bm.width; x++ {
+				d := math.Hypot(float64(x)-19.5, float64(y)-19.5)
+				bm.pix[y*bm.width+x] = d >= 12 && d <= 16
+			}
+		}
+		bm.skeletonize()
+		paths := bm.followSkeleton()
+		require.Len(t, paths, 1)
+		assert.False(t, paths[0].hasLooseEnd)
+		assert.True(t, paths[0].closed)
+		assert.Equal(t, paths[0].points[0], paths[0].points[len(paths[0].points)-1])
+		assert.InDelta(t, 2*math.Pi*14, paths[0].length(), 10)
+	})
+
+	t.Run("cross", func(t *testing.T) {
+		bm := newBitmap(41, 41)
+		for i := 5; i < 36; i++ {
+			for d := 19; d < 22; d++ {
+				bm.pix[d*bm.width+i] = true
+				bm.pix[i*bm.width+d] = true
+			}
+		}
+		bm.skeletonize()
+		paths := bm.followSkeleton()
+		long := 0
+		for _, path := range paths {
+			if path.length() > 10 {
+				long++
+			}
+		}
+		assert.Equal(t, 4, long)
+	})
+}
+
+func Test_traceCenterline(t *testing.T) {
+	config := defaultTraceConfig()
+	config.TurdSize = 2
+
+	t.Run("ring", func(t *testing.T) {
+		bm := newBitmap(40, 40)
+		for y := 0; y < bm.height; y++ {
+			for x := 0; x < bm.width; x++ {
+				d := math.Hypot(float64(x)-19.5, float64(y)-19.5)
+				bm.pix[y*bm.width+x] = d >= 12 && d <= 16
+			}
+		}
+		geometry := traceCenterline(zap.NewNop(), config, bm)
+		require.Len(t, geometry.Paths, 1)
+		path := geometry.Paths[0]
+		assert.True(t, path.Closed)
+		require.NotEmpty(t, path.Curves)
+		assert.Equal(t, path.Curves[0].P0, path.Curves[len(path.Curves)-1].P3)
+	})
+
+	t.Run("line", func(t *testing.T) {
+		bm := newBitmap(60, 20)
+		for y := 8; y < 13; y++ {
+			for x := 5; x < 55; x++ {
+				bm.pix[y*bm.width+x] = true
+			}
+		}
+		geometry := traceCenterline(zap.NewNop(), config, bm)
+		require.Len(t, geometry.Paths, 1)
+		assert.False(t, geometry.Paths[0].Closed)
+	})
+}
+
+func Test_cubicBezier_bounds(t *testing.T) {
+	t.Run("control points outside", func(t *testing.T) {
+		// Symmetric arch whose control points lie above its apex at y = 7.5.
+		curve := cubicBezier{P0: point{X: 0, Y: 0}, P1: point{X: 0, Y: 10}, P2: point{X: 10, Y: 10}, P3: point{X: 10, Y: 0}}
+		minPoint, maxPoint := curve.bounds()
+		assert.Equal(t, point{X: 0, Y: 0}, minPoint)
+		assert.InDelta(t, 10, maxPoint.X, 1e-9)
+		assert.InDelta(t, 7.5, maxPoint.Y, 1e-9)
+	})
+
+	t.Run("line", func(t *testing.T) {
+		minPoint, maxPoint := lineBezier(point{X: 4, Y: 1}, point{X: 2, Y: 3}).bounds()
+		assert.Equal(t, point{X: 2, Y: 1}, minPoint)
+		assert.Equal(t, point{X: 4, Y: 3}, maxPoint)
+	})
+
+	t.Run("matches sampling", func(t *testing.T) {
+		curve := cubicBezier{P0: point{X: 1, Y: 2}, P1: point{X: -5, Y: 8}, P2: point{X: 12, Y: -6}, P3: point{X: 4, Y: 3}}
+		minPoint, maxPoint := curve.bounds()
+		sampledMin, sampledMax := curve.P0, curve.P0
+		for u := 0.0; u <= 1; u += 1e-4 {
+			p := curve.at(u)
+			sampledMin = point{X: min(sampledMin.X, p.X), Y: min(sampledMin.Y, p.Y)}
+			sampledMax = point{X: max(sampledMax.X, p.X), Y: max(sampledMax.Y, p.Y)}
+		}
+		assert.InDelta(t, sampledMin.X, minPoint.X, 1e-6)
+		assert.InDelta(t, sampledMin.Y, minPoint.Y, 1e-6)
+		assert.InDelta(t, sampledMax.X, maxPoint.X, 1e-6)
+		assert.InDelta(t, sampledMax.Y, maxPoint.Y, 1e-6)
+	})
+}
+
+func Test_fitCubicBeziers(t *testing.T) {
+	points := make([]point, 0)
+	for i := 0; i <= 50; i++ {
+		angle := math.Pi * float64(i) / 50
+		points = append(points, point{X: 20 * math.Cos(angle), Y: 20 * math.Sin(angle)})
+	}
+	curves := fitCubicBeziers(points, 0.5)
+	require.NotEmpty(t, curves)
+	assert.Less(t, len(curves), 5)
+	assert.Equal(t, points[0], curves[0].P0)
+	assert.Equal(t, points[len(points)-1], curves[len(curves)-1].P3)
+	for _, curve := range curves {
+		for u := 0.0; u <= 1; u += 0.1 {
+			assert.InDelta(t, 20, curve.at(u).length(), 1)
+		}
+	}
+}
+
+func BenchmarkBitmapSkeletonize(b *testing.B) {
+	original := thresholdBitmap(benchmarkImage(2000, 1500), .5, false)
+	bm := newBitmap(original.width, original.height)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(bm.pix, original.pix)
+		bm.skeletonize()
+	}
+}
+
+func BenchmarkBitmapDespeckle(b *testing.B) {
+	original := thresholdBitmap(benchmarkImage(2000, 1500), .5, false)
+	bm := newBitmap(original.width, original.height)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(bm.pix, original.pix)
+		bm.despeckle(100)
+	}
+}
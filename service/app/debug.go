@@ -0,0 +1,101 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lefinal/image-to-ma3-scribble/web"
+	"github.com/lefinal/meh"
+	"go.uber.org/zap"
+	"image"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// debugStagePreprocessed selects the preprocessed image.
+	debugStagePreprocessed = "preprocessed"
+	// debugStageLayerPrefix selects the bitmap of a color layer, e.g. "layer:0".
+	debugStageLayerPrefix = "layer:"
+)
+
+const (
+	// headerDebugLayerCount is the response header that holds the number of color
+	// layers that are traced.
+	headerDebugLayerCount = "X-Debug-Layer-Count"
+	// headerDebugLayerColor is the response header that holds the color of the
+	// requested layer.
+	headerDebugLayerColor = "X-Debug-Layer-Color"
+)
+
+// debugStage selects the image that handleImageToMA3ScribbleDebug responds
+// with.
+type debugStage struct {
+	// Layer is the index of the color layer. It is -1 for the preprocessed image.
+	Layer int
+}
+
+// parseDebugStage parses either debugStagePreprocessed or debugStageLayerPrefix
+// followed by the layer index. An empty string selects the preprocessed image.
+func parseDebugStage(s string) (debugStage, error) {
+	if s == "" || s == debugStagePreprocessed {
+		return debugStage{Layer: -1}, nil
+	}
+	layerStr, ok := strings.CutPrefix(s, debugStageLayerPrefix)
+	if !ok {
+		return debugStage{}, meh.NewBadInputErr(fmt.Sprintf("unsupported debug stage: %s", s),
+			meh.Details{"allowed": []string{debugStagePreprocessed, debugStageLayerPrefix + "<index>"}})
+	}
+	layer, err := strconv.Atoi(layerStr)
+	if err != nil {
+		return debugStage{}, meh.NewBadInputErrFromErr(err, "parse debug layer index", meh.Details{"was": layerStr})
+	}
+	if layer < 0 {
+		return debugStage{}, meh.NewBadInputErr("debug layer index must not be negative", meh.Details{"was": layer})
+	}
+	return debugStage{Layer: layer}, nil
+}
+
+// handleImageToMA3ScribbleDebug accepts the same query params as
+// handleImageToMA3Scribble, but responds with an intermediate image as PNG. The
+// query param debug_stage selects either the preprocessed image or the bilevel
+// image of a color layer exactly as it is handed to the tracer. In the latter,
+// ink is black and small areas are not removed yet, as the tracer does this
+// based on the turd size.
+func (app *App) handleImageToMA3ScribbleDebug() web.HandlerFunc {
+	return func(logger *zap.Logger, c *gin.Context) error {
+		stage, err := parseDebugStage(c.Query("debug_stage"))
+		if err != nil {
+			return meh.Wrap(err, "parse debug stage", nil)
+		}
+		prepared, err := app.prepareTrace(logger, c)
+		if err != nil {
+			return meh.Wrap(err, "prepare trace", nil)
+		}
+		c.Header(headerDebugLayerCount, strconv.Itoa(len(prepared.Layers)))
+
+		var img image.Image
+		if stage.Layer < 0 {
+			img = prepared.Preprocessed.Image
+		} else {
+			if stage.Layer >= len(prepared.Layers) {
+				return meh.NewBadInputErr(fmt.Sprintf("debug layer %d out of range", stage.Layer),
+					meh.Details{"layer_count": len(prepared.Layers)})
+			}
+			layer := prepared.Layers[stage.Layer]
+			c.Header(headerBlackLevel, strconv.FormatFloat(layer.BlackLevel, 'f', 4, 64))
+			c.Header(headerDebugLayerColor, rgbaToHex(layer.Color))
+			img = layer.Bitmap.gray()
+		}
+
+		var imgPNG bytes.Buffer
+		err = png.Encode(&imgPNG, img)
+		if err != nil {
+			return meh.NewInternalErrFromErr(err, "encode png", meh.Details{"layer": stage.Layer})
+		}
+		c.Data(http.StatusOK, "image/png", imgPNG.Bytes())
+		return nil
+	}
+}
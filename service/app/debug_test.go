@@ -0,0 +1,36 @@
+package app
+
+import (
+	"github.com/lefinal/meh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_parseDebugStage(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    debugStage
+		wantErr bool
+	}{
+		{name: "default", s: "", want: debugStage{Layer: -1}},
+		{name: "preprocessed", s: "preprocessed", want: debugStage{Layer: -1}},
+		{name: "layer", s: "layer:2", want: debugStage{Layer: 2}},
+		{name: "negative layer", s: "layer:-1", wantErr: true},
+		{name: "invalid layer", s: "layer:x", wantErr: true},
+		{name: "unsupported", s: "bilevel", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDebugStage(tt.s)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Equal(t, meh.ErrBadInput, meh.ErrorCode(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/lefinal/meh"
+	"image"
+	"io"
+	"net/http"
+
+	// Register decoders for all supported input formats.
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// supportedImageFormats lists the format names as registered with image.Decode
+// that we accept as input.
+var supportedImageFormats = []string{"png", "jpeg", "gif", "webp", "bmp", "tiff"}
+
+// sniffLen is the number of bytes used for detecting the content type of
+// unsupported input.
+const sniffLen = 512
+
+const (
+	// DefaultMaxImageWidth is the default for Config.MaxImageWidth.
+	DefaultMaxImageWidth = 16384
+	// DefaultMaxImageHeight is the default for Config.MaxImageHeight.
+	DefaultMaxImageHeight = 16384
+	// DefaultMaxImagePixels is the default for Config.MaxImagePixels.
+	DefaultMaxImagePixels = 50_000_000
+)
+
+// imageLimits restricts the dimensions of uploaded images. Zero values disable
+// the respective limit.
+type imageLimits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int
+}
+
+// checkImageLimits reads the image header from r and checks the declared
+// dimensions against the given limits without decoding the image. This protects
+// against decompression bombs, i.e., small files that declare huge dimensions.
+// Unsupported formats are not reported here, so that decodeImage can name them.
+func checkImageLimits(r io.Reader, limits imageLimits) error {
+	imgConfig, format, err := image.DecodeConfig(r)
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil
+		}
+		return meh.NewBadInputErrFromErr(err, fmt.Sprintf("decode %s image config", format), nil)
+	}
+	return limits.check(imgConfig.Width, imgConfig.Height)
+}
+
+// check checks the given dimensions against the limits.
+func (limits imageLimits) check(width, height int) error {
+	pixels := int64(width) * int64(height)
+	if (limits.MaxWidth > 0 && width > limits.MaxWidth) ||
+		(limits.MaxHeight > 0 && height > limits.MaxHeight) ||
+		(limits.MaxPixels > 0 && pixels > int64(limits.MaxPixels)) {
+		return meh.NewBadInputErr(fmt.Sprintf("image dimensions %dx%d (%d pixels) exceed limits of max width %d, max height %d and max pixels %d",
+			width, height, pixels, limits.MaxWidth, limits.MaxHeight, limits.MaxPixels), meh.Details{
+			"width":      width,
+			"height":     height,
+			"pixels":     pixels,
+			"max_width":  limits.MaxWidth,
+			"max_height": limits.MaxHeight,
+			"max_pixels": limits.MaxPixels,
+		})
+	}
+	return nil
+}
+
+// decodeImage decodes an image of any supported format from the given reader.
+// The format is detected from the content. It returns the decoded image as well
+// as the detected format name.
+func decodeImage(r io.Reader) (image.Image, string, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	// Peek for being able to name the detected content type in case of unsupported
+	// formats.
+	head, err := br.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, "", meh.NewBadInputErrFromErr(err, "read image header", nil)
+	}
+	img, format, err := image.Decode(br)
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			detected := http.DetectContentType(head)
+			return nil, "", meh.NewBadInputErr(fmt.Sprintf("unsupported image format: %s", detected), meh.Details{
+				"detected_content_type": detected,
+				"supported":             supportedImageFormats,
+			})
+		}
+		return nil, "", meh.NewBadInputErrFromErr(err, fmt.Sprintf("decode %s image", format), nil)
+	}
+	return img, format, nil
+}
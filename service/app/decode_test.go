@@ -0,0 +1,135 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/lefinal/meh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// pngWithDeclaredSize returns a valid 1x1 PNG whose header declares the given
+// dimensions.
+func pngWithDeclaredSize(t *testing.T, width, height uint32) []byte {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+	// Signature (8), IHDR length (4) and type (4) precede width and height.
+	binary.BigEndian.PutUint32(raw[16:20], width)
+	binary.BigEndian.PutUint32(raw[20:24], height)
+	// CRC covers type and data of the 13 bytes long IHDR chunk.
+	binary.BigEndian.PutUint32(raw[29:33], crc32.ChecksumIEEE(raw[12:29]))
+	return raw
+}
+
+func Test_checkImageLimits(t *testing.T) {
+	limits := imageLimits{
+		MaxWidth:  1000,
+		MaxHeight: 500,
+		MaxPixels: 100_000,
+	}
+	tests := []struct {
+		name    string
+		width   uint32
+		height  uint32
+		limits  imageLimits
+		wantErr bool
+	}{
+		{name: "within limits", width: 300, height: 300, limits: limits},
+		{name: "too wide", width: 1001, height: 10, limits: limits, wantErr: true},
+		{name: "too high", width: 10, height: 501, limits: limits, wantErr: true},
+		{name: "too many pixels", width: 400, height: 400, limits: limits, wantErr: true},
+		{name: "bomb", width: 50_000, height: 50_000, limits: limits, wantErr: true},
+		{name: "no limits", width: 50_000, height: 50_000, limits: imageLimits{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkImageLimits(bytes.NewReader(pngWithDeclaredSize(t, tt.width, tt.height)), tt.limits)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("unsupported format", func(t *testing.T) {
+		err := checkImageLimits(bytes.NewReader([]byte("hello world")), limits)
+		assert.NoError(t, err)
+	})
+}
+
+// webp1x1 is a lossy WebP image with a single gray pixel. There is no WebP
+// encoder in the standard library or golang.org/x/image.
+const webp1x1 = "UklGRiIAAABXRUJQVlA4IBYAAAAwAQCdASoBAAEADsD+JaQAA3AAAAAA"
+
+func Test_decodeImage(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 4, 3))
+	// encoded returns img encoded with the given function.
+	encoded := func(encode func(w io.Writer, img image.Image) error) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, encode(&buf, img))
+		return buf.Bytes()
+	}
+	webp, err := base64.StdEncoding.DecodeString(webp1x1)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		wantFormat string
+		wantBounds image.Rectangle
+	}{
+		{name: "png", data: encoded(png.Encode), wantFormat: "png", wantBounds: img.Bounds()},
+		{name: "jpeg", data: encoded(func(w io.Writer, img image.Image) error { return jpeg.Encode(w, img, nil) }), wantFormat: "jpeg", wantBounds: img.Bounds()},
+		{name: "gif", data: encoded(func(w io.Writer, img image.Image) error { return gif.Encode(w, img, nil) }), wantFormat: "gif", wantBounds: img.Bounds()},
+		{name: "webp", data: webp, wantFormat: "webp", wantBounds: image.Rect(0, 0, 1, 1)},
+		{name: "bmp", data: encoded(bmp.Encode), wantFormat: "bmp", wantBounds: img.Bounds()},
+		{name: "tiff", data: encoded(func(w io.Writer, img image.Image) error { return tiff.Encode(w, img, nil) }), wantFormat: "tiff", wantBounds: img.Bounds()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, format, err := decodeImage(bytes.NewReader(tt.data))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantFormat, format)
+			assert.Equal(t, tt.wantBounds, got.Bounds())
+		})
+	}
+}
+
+func Test_decodeImage_unsupported(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		wantDetected string
+	}{
+		{name: "pdf", data: "%PDF-1.7\n%\xe2\xe3\xcf\xd3\n", wantDetected: "application/pdf"},
+		{name: "svg", data: `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"/>`, wantDetected: "text/xml; charset=utf-8"},
+		{name: "svg without declaration", data: `<svg xmlns="http://www.w3.org/2000/svg"></svg>`, wantDetected: "text/plain; charset=utf-8"},
+		{name: "empty", data: "", wantDetected: "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decodeImage(bytes.NewReader([]byte(tt.data)))
+			require.Error(t, err)
+			assert.Equal(t, meh.ErrBadInput, meh.ErrorCode(err))
+			assert.Contains(t, err.Error(), "unsupported image format: "+tt.wantDetected)
+			var e *meh.Error
+			require.ErrorAs(t, err, &e)
+			assert.Equal(t, tt.wantDetected, e.Details["detected_content_type"])
+		})
+	}
+}
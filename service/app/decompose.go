@@ -0,0 +1,267 @@
+package app
+
+// gridPoint is a corner on the pixel grid.
+type gridPoint struct {
+	X, Y int
+}
+
+// outlinePath is a closed path along pixel edges that encloses an ink
+// component or a hole. Coordinates have the y-axis pointing up like in potrace,
+// so that pixel (x, y) of the bitmap spans from (x, height-y-1) to
+// (x+1, height-y).
+type outlinePath struct {
+	points []gridPoint
+	// area is the number of enclosed pixels.
+	area int
+	// hole is true for paths around holes in ink components.
+	hole bool
+}
+
+// decomposeOutlines splits the bitmap into closed paths around ink components
+// and their holes. Paths enclosing at most turdSize pixels are dropped. The
+// turn policy is one of allowedTraceTurnPolicies and resolves ambiguous turns
+// where two ink pixels only touch diagonally. This is the path decomposition of
+// potrace. Each path is followed by its holes and then by the contents of these
+// holes, which is the order in which potrace writes them.
+func decomposeOutlines(bm *bitmap, turnPolicy string, turdSize int) []outlinePath {
+	// Work with the y-axis pointing up.
+	original := newBitmap(bm.width, bm.height)
+	for y := 0; y < bm.height; y++ {
+		copy(original.pix[y*bm.width:(y+1)*bm.width], bm.pix[(bm.height-y-1)*bm.width:(bm.height-y)*bm.width])
+	}
+	// Each found path is inverted in the working copy, so that holes show up as
+	// ink for the following search.
+	work := newBitmap(original.width, original.height)
+	copy(work.pix, original.pix)
+
+	paths := make([]outlinePath, 0)
+	x, y := 0, work.height-1
+	for {
+		var found bool
+		x, y, found = work.findNext(x, y)
+		if !found {
+			break
+		}
+		path := work.findPath(x, y+1, !original.at(x, y), turnPolicy)
+		work.xorPath(path)
+		if path.area <= turdSize {
+			continue
+		}
+		paths = append(paths, path)
+	}
+
+	// Clear the working copy for rendering paths when building the tree.
+	clear(work.pix)
+	ordered := make([]outlinePath, 0, len(paths))
+	for _, node := range work.outlineTree(paths) {
+		ordered = node.appendOrdered(ordered)
+	}
+	return ordered
+}
+
+// outlineNode is a path with the paths directly inside it.
+type outlineNode struct {
+	path     outlinePath
+	children []*outlineNode
+}
+
+// appendOrdered appends the path, its children and then the contents of the
+// children to the given list.
+func (node *outlineNode) appendOrdered(paths []outlinePath) []outlinePath {
+	paths = append(paths, node.path)
+	for _, child := range node.children {
+		paths = append(paths, child.path)
+	}
+	for _, child := range node.children {
+		for _, grandchild := range child.children {
+			paths = grandchild.appendOrdered(paths)
+		}
+	}
+	return paths
+}
+
+// outlineTree arranges the given paths as tree by insideness. Paths must be in
+// the order from decomposition and the bitmap must be clear. It is used for
+// rendering paths and is clear again afterward.
+func (bm *bitmap) outlineTree(paths []outlinePath) []*outlineNode {
+	nodes := make([]*outlineNode, 0)
+	for len(paths) > 0 {
+		head := &outlineNode{path: paths[0]}
+		nodes = append(nodes, head)
+		bm.xorPath(head.path)
+		minY := head.path.points[0].Y
+		for _, p := range head.path.points {
+			minY = min(minY, p.Y)
+		}
+		inside := make([]outlinePath, 0)
+		outside := make([]outlinePath, 0)
+		for i, path := range paths[1:] {
+			// Paths are ordered by their top row. So are all following ones.
+			start := path.points[0]
+			if start.Y <= minY {
+				outside = append(outside, paths[1+i:]...)
+				break
+			}
+			if bm.at(start.X, start.Y-1) {
+				inside = append(inside, path)
+			} else {
+				outside = append(outside, path)
+			}
+		}
+		bm.xorPath(head.path)
+		head.children = bm.outlineTree(inside)
+		paths = outside
+	}
+	return nodes
+}
+
+// findNext returns the next ink pixel when scanning rows from top to bottom and
+// each row from left to right, starting at the given pixel.
+func (bm *bitmap) findNext(x, y int) (int, int, bool) {
+	for ; y >= 0; y-- {
+		for ; x < bm.width; x++ {
+			if bm.pix[y*bm.width+x] {
+				return x, y, true
+			}
+		}
+		x = 0
+	}
+	return 0, 0, false
+}
+
+// findPath follows the boundary of the component whose top-left corner is at
+// the given grid point, keeping ink to the left.
+func (bm *bitmap) findPath(x0, y0 int, hole bool, turnPolicy string) outlinePath {
+	path := outlinePath{
+		points: make([]gridPoint, 0),
+		hole:   hole,
+	}
+	x, y := x0, y0
+	dirX, dirY := 0, -1
+	for {
+		path.points = append(path.points, gridPoint{X: x, Y: y})
+		x += dirX
+		y += dirY
+		path.area += x * dirY
+		if x == x0 && y == y0 {
+			break
+		}
+		// Pixels ahead to the right and left.
+		right := bm.at(x+(dirX+dirY-1)/2, y+(dirY-dirX-1)/2)
+		left := bm.at(x+(dirX-dirY-1)/2, y+(dirY+dirX-1)/2)
+		turnRight := false
+		switch {
+		case right && !left:
+			// Ambiguous.
+			switch turnPolicy {
+			case "right":
+				turnRight = true
+			case "black":
+				turnRight = !hole
+			case "white":
+				turnRight = hole
+			case "random":
+				turnRight = detRand(x, y)
+			case "majority":
+				turnRight = bm.majority(x, y)
+			case "minority":
+				turnRight = !bm.majority(x, y)
+			}
+		case right:
+			turnRight = true
+		case left:
+			// Straight on.
+			continue
+		}
+		if turnRight {
+			dirX, dirY = dirY, -dirX
+		} else {
+			dirX, dirY = -dirY, dirX
+		}
+	}
+	return path
+}
+
+// majority reports whether ink is the local majority around the given grid
+// point. The neighborhood grows until there is a majority.
+func (bm *bitmap) majority(x, y int) bool {
+	for i := 2; i < 5; i++ {
+		count := 0
+		vote := func(set bool) {
+			if set {
+				count++
+			} else {
+				count--
+			}
+		}
+		for a := -i + 1; a <= i-1; a++ {
+			vote(bm.at(x+a, y+i-1))
+			vote(bm.at(x+i-1, y+a-1))
+			vote(bm.at(x+a-1, y-i))
+			vote(bm.at(x-i, y+a))
+		}
+		if count > 0 {
+			return true
+		} else if count < 0 {
+			return false
+		}
+	}
+	return false
+}
+
+// detRandTable holds for each byte the constant term of its inverse in GF(2^8)
+// modulo x^8+x^4+x^3+x+1. This is the non-linear sequence used by potrace.
+var detRandTable = func() [256]uint8 {
+	multiply := func(a, b uint8) uint8 {
+		product := uint8(0)
+		for ; b > 0; b >>= 1 {
+			if b&1 == 1 {
+				product ^= a
+			}
+			carry := a & 0x80
+			a <<= 1
+			if carry != 0 {
+				a ^= 0x1b
+			}
+		}
+		return product
+	}
+	var table [256]uint8
+	for a := 1; a < 256; a++ {
+		for inverse := 1; inverse < 256; inverse++ {
+			if multiply(uint8(a), uint8(inverse)) == 1 {
+				table[a] = uint8(inverse) & 1
+				break
+			}
+		}
+	}
+	return table
+}()
+
+// detRand returns a pseudo-random but deterministic decision for the given
+// grid point. It matches the random turn policy of potrace.
+func detRand(x, y int) bool {
+	z := (0x04b3e375*uint32(x) ^ uint32(y)) * 0x05a8ef93
+	return detRandTable[z&0xff]^detRandTable[(z>>8)&0xff]^detRandTable[(z>>16)&0xff]^detRandTable[(z>>24)&0xff] == 1
+}
+
+// xorPath inverts all pixels enclosed by the given path.
+func (bm *bitmap) xorPath(path outlinePath) {
+	if len(path.points) == 0 {
+		return
+	}
+	// Invert each row between the path and a reference column. Pixels outside the
+	// path are inverted an even number of times.
+	ref := path.points[0].X
+	prevY := path.points[len(path.points)-1].Y
+	for _, p := range path.points {
+		if p.Y == prevY {
+			continue
+		}
+		row := bm.pix[min(p.Y, prevY)*bm.width:]
+		for x := min(p.X, ref); x < max(p.X, ref); x++ {
+			row[x] = !row[x]
+		}
+		prevY = p.Y
+	}
+}
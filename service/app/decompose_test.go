@@ -0,0 +1,72 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// bitmapFromRows creates a bitmap from the given rows where '#' is ink.
+func bitmapFromRows(rows ...string) *bitmap {
+	bm := newBitmap(len(rows[0]), len(rows))
+	for y, row := range rows {
+		for x, c := range row {
+			bm.pix[y*bm.width+x] = c == '#'
+		}
+	}
+	return bm
+}
+
+func Test_decomposeOutlines(t *testing.T) {
+	t.Run("nested", func(t *testing.T) {
+		bm := bitmapFromRows(
+			"#######.##",
+			"#.....#.##",
+			"#.###.#...",
+			"#.###.#...",
+			"#.....#...",
+			"#######...",
+		)
+		paths := decomposeOutlines(bm, "minority", 0)
+		require.Len(t, paths, 4)
+		// Outer ring, its hole and the contents of the hole. The square on the
+		// right is found after the ring, but it is not inside it.
+		assert.False(t, paths[0].hole)
+		assert.Equal(t, 42, paths[0].area)
+		assert.True(t, paths[1].hole)
+		assert.Equal(t, 20, paths[1].area)
+		assert.False(t, paths[2].hole)
+		assert.Equal(t, 6, paths[2].area)
+		assert.False(t, paths[3].hole)
+		assert.Equal(t, 4, paths[3].area)
+		// The y-axis points up.
+		assert.Equal(t, gridPoint{X: 0, Y: 6}, paths[0].points[0])
+	})
+
+	t.Run("turd size", func(t *testing.T) {
+		bm := bitmapFromRows(
+			"##....",
+			"##.###",
+			"...###",
+		)
+		paths := decomposeOutlines(bm, "minority", 4)
+		require.Len(t, paths, 1)
+		assert.Equal(t, 6, paths[0].area)
+	})
+
+	t.Run("turn policy", func(t *testing.T) {
+		bm := bitmapFromRows(
+			"##..",
+			"##..",
+			"..##",
+			"..##",
+		)
+		assert.Len(t, decomposeOutlines(bm, "black", 0), 1)
+		assert.Len(t, decomposeOutlines(bm, "white", 0), 2)
+	})
+}
+
+func Test_detRandTable(t *testing.T) {
+	// Constant terms of the inverses of 1, 2 (0x8d), 3 (0xf6) and 4 (0xcb).
+	assert.Equal(t, []uint8{0, 1, 1, 0, 1}, detRandTable[:5])
+}
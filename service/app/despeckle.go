@@ -0,0 +1,119 @@
+package app
+
+import (
+	"image"
+	"math"
+)
+
+// maxMedianSize limits the kernel size of the median filter.
+const maxMedianSize = 25
+
+// despeckle removes dark islands with an area of less than minArea pixels.
+// Islands are 8-connected. This is done via a grayscale area closing, which for
+// bilevel images equals removing black connected components that are smaller
+// than minArea. For grayscale images, small dark spots are raised to the level
+// of their surroundings, independent of the later black level.
+func despeckle(img image.Image, minArea int) *image.Gray {
+	gray := grayIntensity(img)
+	// Area closing is an area opening on the inverted image.
+	for i := range gray.Pix {
+		gray.Pix[i] = math.MaxUint8 - gray.Pix[i]
+	}
+	opened := areaOpening(gray, minArea)
+	for i := range opened.Pix {
+		opened.Pix[i] = math.MaxUint8 - opened.Pix[i]
+	}
+	return opened
+}
+
+// areaOpening removes bright connected components with an area of less than
+// minArea pixels at every gray level. It implements the union-find algorithm
+// by Meijster and Wilkinson.
+func areaOpening(gray *image.Gray, minArea int) *image.Gray {
+	bounds := gray.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	n := width * height
+	values := make([]uint8, n)
+	for y := 0; y < height; y++ {
+		copy(values[y*width:(y+1)*width], gray.Pix[y*gray.Stride:y*gray.Stride+width])
+	}
+
+	// Sort pixels by decreasing value using counting sort.
+	var counts [math.MaxUint8 + 1]int
+	for _, v := range values {
+		counts[v]++
+	}
+	var starts [math.MaxUint8 + 1]int
+	offset := 0
+	for v := math.MaxUint8; v >= 0; v-- {
+		starts[v] = offset
+		offset += counts[v]
+	}
+	sorted := make([]int, n)
+	for i, v := range values {
+		sorted[starts[v]] = i
+		starts[v]++
+	}
+
+	// Build the union-find forest. Unprocessed pixels have parent -1.
+	parent := make([]int, n)
+	for i := range parent {
+		parent[i] = -1
+	}
+	area := make([]int, n)
+	findRoot := func(p int) int {
+		for parent[p] != p {
+			// Path halving.
+			parent[p] = parent[parent[p]]
+			p = parent[p]
+		}
+		return p
+	}
+	for _, p := range sorted {
+		parent[p] = p
+		area[p] = 1
+		px, py := p%width, p/width
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				nx, ny := px+dx, py+dy
+				if (dx == 0 && dy == 0) || nx < 0 || nx >= width || ny < 0 || ny >= height {
+					continue
+				}
+				q := ny*width + nx
+				if parent[q] == -1 {
+					continue
+				}
+				r := findRoot(q)
+				if r == p {
+					continue
+				}
+				if values[r] == values[p] || area[r] < minArea {
+					// Merge the component into the current pixel's one.
+					area[p] += area[r]
+					parent[r] = p
+				} else {
+					// The neighboring component satisfies the criterion and stays as is. So
+					// does the current one.
+					area[p] = minArea
+				}
+			}
+		}
+	}
+
+	// Resolve output values in increasing order, so that parents are resolved
+	// before their children.
+	out := make([]uint8, n)
+	for i := n - 1; i >= 0; i-- {
+		p := sorted[i]
+		if parent[p] == p {
+			out[p] = values[p]
+		} else {
+			out[p] = out[parent[p]]
+		}
+	}
+	dst := image.NewGray(bounds)
+	for y := 0; y < height; y++ {
+		copy(dst.Pix[y*dst.Stride:y*dst.Stride+width], out[y*width:(y+1)*width])
+	}
+	return dst
+}
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"testing"
+)
+
+func Test_despeckle(t *testing.T) {
+	// White image with a single black pixel, a diagonal pair (8-connected) and a
+	// 3x3 black square.
+	gray := image.NewGray(image.Rect(0, 0, 12, 8))
+	for i := range gray.Pix {
+		gray.Pix[i] = 255
+	}
+	gray.Pix[gray.PixOffset(1, 1)] = 0
+	gray.Pix[gray.PixOffset(4, 4)] = 0
+	gray.Pix[gray.PixOffset(5, 5)] = 0
+	for y := 2; y < 5; y++ {
+		for x := 8; x < 11; x++ {
+			gray.Pix[gray.PixOffset(x, y)] = 0
+		}
+	}
+
+	t.Run("remove single pixels", func(t *testing.T) {
+		got := despeckle(gray, 2)
+		assert.Equal(t, uint8(255), got.GrayAt(1, 1).Y)
+		assert.Equal(t, uint8(0), got.GrayAt(4, 4).Y)
+		assert.Equal(t, uint8(0), got.GrayAt(5, 5).Y)
+		assert.Equal(t, uint8(0), got.GrayAt(9, 3).Y)
+	})
+
+	t.Run("remove pairs", func(t *testing.T) {
+		got := despeckle(gray, 3)
+		assert.Equal(t, uint8(255), got.GrayAt(1, 1).Y)
+		assert.Equal(t, uint8(255), got.GrayAt(4, 4).Y)
+		assert.Equal(t, uint8(255), got.GrayAt(5, 5).Y)
+		assert.Equal(t, uint8(0), got.GrayAt(9, 3).Y)
+	})
+
+	t.Run("remove all", func(t *testing.T) {
+		got := despeckle(gray, 10)
+		for _, v := range got.Pix {
+			assert.Equal(t, uint8(255), v)
+		}
+	})
+
+	t.Run("gray spot", func(t *testing.T) {
+		spotted := image.NewGray(image.Rect(0, 0, 5, 5))
+		for i := range spotted.Pix {
+			spotted.Pix[i] = 200
+		}
+		spotted.Pix[spotted.PixOffset(2, 2)] = 50
+		got := despeckle(spotted, 2)
+		assert.Equal(t, uint8(200), got.GrayAt(2, 2).Y)
+	})
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"github.com/disintegration/gift"
+	"image"
+	"math"
+)
+
+const (
+	edgeDetectionNone  = "none"
+	edgeDetectionSobel = "sobel"
+	edgeDetectionCanny = "canny"
+)
+
+var allowedEdgeDetectionMethods = []string{edgeDetectionNone, edgeDetectionSobel, edgeDetectionCanny}
+
+// maxEdgeThickness limits the radius for thickening detected edges.
+const maxEdgeThickness = 20
+
+// sobelMaxMagnitude is the largest possible Sobel gradient magnitude for
+// intensities from 0 to 1.
+var sobelMaxMagnitude = 4 * math.Sqrt2
+
+// edgeDetectionOptions configures turning an image into line art of its edges.
+type edgeDetectionOptions struct {
+	// Method is one of allowedEdgeDetectionMethods.
+	Method string
+	// Sigma of the Gaussian smoothing before Canny edge detection.
+	Sigma float64
+	// LowThreshold is the gradient magnitude from 0 to 1 that weak edges need for
+	// being kept if they are connected to strong ones. Only used with Canny. Like
+	// HighThreshold, it is relative to the maximum gradient magnitude in the
+	// image.
+	LowThreshold float64
+	// HighThreshold is the gradient magnitude from 0 to 1 for strong edges. It is
+	// relative to the maximum gradient magnitude in the image, as smoothing keeps
+	// gradients of photos well below the theoretical maximum.
+	HighThreshold float64
+	// Thickness is the radius by which detected edges are thickened.
+	Thickness int
+}
+
+// detectEdges creates a bilevel image with detected edges in black on white.
+func detectEdges(img image.Image, options edgeDetectionOptions) *image.Gray {
+	gray := grayIntensity(img)
+	if options.Method == edgeDetectionCanny && options.Sigma > 0 {
+		g := gift.New(gift.GaussianBlur(float32(options.Sigma)))
+		blurred := image.NewGray(g.Bounds(gray.Bounds()))
+		g.Draw(blurred, gray)
+		gray = blurred
+	}
+	magnitudes, directions := sobelGradients(gray)
+	normalizeMagnitudes(magnitudes)
+	var edges []bool
+	switch options.Method {
+	case edgeDetectionCanny:
+		edges = cannyEdges(gray.Bounds().Dx(), gray.Bounds().Dy(), magnitudes, directions, options.LowThreshold, options.HighThreshold)
+	default:
+		edges = make([]bool, len(magnitudes))
+		for i, magnitude := range magnitudes {
+			edges[i] = magnitude >= options.HighThreshold
+		}
+	}
+	bounds := gray.Bounds()
+	width := bounds.Dx()
+	out := image.NewGray(bounds)
+	for i, edge := range edges {
+		if !edge {
+			out.Pix[(i/width)*out.Stride+i%width] = math.MaxUint8
+		}
+	}
+	if options.Thickness > 0 {
+		out = morphologyFilter(out, morphologyKernelDisk, options.Thickness, true)
+	}
+	return out
+}
+
+// sobelGradients computes the normalized gradient magnitude from 0 to 1 and the
+// gradient direction in radians for each pixel. Borders are handled by
+// clamping coordinates.
+func sobelGradients(gray *image.Gray) ([]float64, []float64) {
+	bounds := gray.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	magnitudes := make([]float64, width*height)
+	directions := make([]float64, width*height)
+	at := func(x, y int) float64 {
+		x = min(max(x, 0), width-1)
+		y = min(max(y, 0), height-1)
+		return float64(gray.Pix[y*gray.Stride+x]) / math.MaxUint8
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			gx := -at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1) + at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1)
+			gy := -at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1) + at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1)
+			magnitudes[y*width+x] = math.Hypot(gx, gy) / sobelMaxMagnitude
+			directions[y*width+x] = math.Atan2(gy, gx)
+		}
+	}
+	return magnitudes, directions
+}
+
+// normalizeMagnitudes scales the given gradient magnitudes in place, so that
+// the maximum one is 1. If all are zero, they are left unchanged.
+func normalizeMagnitudes(magnitudes []float64) {
+	maxMagnitude := 0.0
+	for _, magnitude := range magnitudes {
+		maxMagnitude = max(maxMagnitude, magnitude)
+	}
+	if maxMagnitude == 0 {
+		return
+	}
+	for i := range magnitudes {
+		magnitudes[i] /= maxMagnitude
+	}
+}
+
+// cannyEdges performs non-maximum suppression and hysteresis thresholding on
+// the given gradients.
+func cannyEdges(width, height int, magnitudes, directions []float64, low, high float64) []bool {
+	// Non-maximum suppression: keep only pixels whose magnitude is a local maximum
+	// along the gradient direction.
+	suppressed := make([]float64, len(magnitudes))
+	magnitudeAt := func(x, y int) float64 {
+		if x < 0 || x >= width || y < 0 || y >= height {
+			return 0
+		}
+		return magnitudes[y*width+x]
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			// Quantize the direction to one of four neighbor axes.
+			angle := math.Mod(directions[i]*180/math.Pi+180, 180)
+			var dx, dy int
+			switch {
+			case angle < 22.5 || angle >= 157.5:
+				dx, dy = 1, 0
+			case angle < 67.5:
+				dx, dy = 1, 1
+			case angle < 112.5:
+				dx, dy = 0, 1
+			default:
+				dx, dy = -1, 1
+			}
+			m := magnitudes[i]
+			if m >= magnitudeAt(x+dx, y+dy) && m >= magnitudeAt(x-dx, y-dy) {
+				suppressed[i] = m
+			}
+		}
+	}
+
+	// Hysteresis: start at strong edges and follow connected weak ones.
+	edges := make([]bool, len(magnitudes))
+	stack := make([]int, 0)
+	for i, m := range suppressed {
+		if m >= high && m > 0 {
+			edges[i] = true
+			stack = append(stack, i)
+		}
+	}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		x, y := i%width, i/width
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				nx, ny := x+dx, y+dy
+				if nx < 0 || nx >= width || ny < 0 || ny >= height {
+					continue
+				}
+				n := ny*width + nx
+				if !edges[n] && suppressed[n] >= low && suppressed[n] > 0 {
+					edges[n] = true
+					stack = append(stack, n)
+				}
+			}
+		}
+	}
+	return edges
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countInk returns the number of black pixels in the given image.
+func countInk(img *image.Gray) int {
+	n := 0
+	for _, v := range img.Pix {
+		if v == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func Test_detectEdges(t *testing.T) {
+	// Black square on white.
+	gray := image.NewGray(image.Rect(0, 0, 30, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 30; x++ {
+			if x < 10 || x >= 20 || y < 10 || y >= 20 {
+				gray.Pix[gray.PixOffset(x, y)] = 255
+			}
+		}
+	}
+
+	for _, method := range []string{edgeDetectionSobel, edgeDetectionCanny} {
+		t.Run(method, func(t *testing.T) {
+			edges := detectEdges(gray, edgeDetectionOptions{
+				Method:        method,
+				Sigma:         1,
+				LowThreshold:  0.1,
+				HighThreshold: 0.2,
+			})
+			assert.Equal(t, uint8(255), edges.GrayAt(2, 2).Y, "background should be white")
+			assert.Equal(t, uint8(255), edges.GrayAt(15, 15).Y, "inside of the square should be white")
+			edgeFound := false
+			for x := 8; x <= 11; x++ {
+				if edges.GrayAt(x, 15).Y == 0 {
+					edgeFound = true
+				}
+			}
+			assert.True(t, edgeFound, "should detect the left edge")
+		})
+	}
+
+	t.Run("thickness", func(t *testing.T) {
+		thin := detectEdges(gray, edgeDetectionOptions{Method: edgeDetectionCanny, Sigma: 1, LowThreshold: 0.1, HighThreshold: 0.2})
+		thick := detectEdges(gray, edgeDetectionOptions{Method: edgeDetectionCanny, Sigma: 1, LowThreshold: 0.1, HighThreshold: 0.2, Thickness: 2})
+		assert.Greater(t, countInk(thick), countInk(thin))
+	})
+
+	t.Run("photo", func(t *testing.T) {
+		f, err := os.Open(filepath.Join("..", "testing", "img-crop.jpg"))
+		require.NoError(t, err)
+		defer func() { _ = f.Close() }()
+		img, _, err := decodeImage(f)
+		require.NoError(t, err)
+		pixels := img.Bounds().Dx() * img.Bounds().Dy()
+
+		// Defaults of the preprocessing options.
+		for _, method := range []string{edgeDetectionSobel, edgeDetectionCanny} {
+			edges := detectEdges(img, edgeDetectionOptions{
+				Method:        method,
+				Sigma:         1.4,
+				LowThreshold:  0.1,
+				HighThreshold: 0.2,
+			})
+			ink := countInk(edges)
+			assert.Greater(t, ink, 100, "%s should find edges", method)
+			assert.Less(t, ink, pixels/4, "%s should not mark everything as edge", method)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/disintegration/gift"
+	"image"
+)
+
+// exifOrientation is the value of the EXIF Orientation tag (0x0112). Values
+// range from 1 to 8 and describe how the stored pixels have to be transformed
+// for being displayed correctly.
+type exifOrientation int
+
+const (
+	exifOrientationUnknown    exifOrientation = 0
+	exifOrientationNormal     exifOrientation = 1
+	exifOrientationFlipH      exifOrientation = 2
+	exifOrientationRotate180  exifOrientation = 3
+	exifOrientationFlipV      exifOrientation = 4
+	exifOrientationTranspose  exifOrientation = 5
+	exifOrientationRotate270  exifOrientation = 6
+	exifOrientationTransverse exifOrientation = 7
+	exifOrientationRotate90   exifOrientation = 8
+)
+
+const exifTagOrientation = 0x0112
+
+// readEXIFOrientation reads the EXIF orientation from the given raw image data.
+// JPEG, WebP and TIFF containers are supported. If no orientation could be
+// found, exifOrientationUnknown is returned.
+func readEXIFOrientation(raw []byte) exifOrientation {
+	switch {
+	case len(raw) >= 2 && raw[0] == 0xFF && raw[1] == 0xD8:
+		return readJPEGEXIFOrientation(raw)
+	case len(raw) >= 12 && string(raw[0:4]) == "RIFF" && string(raw[8:12]) == "WEBP":
+		return readWebPEXIFOrientation(raw)
+	case len(raw) >= 4 && (string(raw[0:4]) == "II*\x00" || string(raw[0:4]) == "MM\x00*"):
+		return readTIFFOrientation(raw)
+	default:
+		return exifOrientationUnknown
+	}
+}
+
+// readJPEGEXIFOrientation searches the APP1 segment of a JPEG file for EXIF
+// data.
+func readJPEGEXIFOrientation(raw []byte) exifOrientation {
+	exifHeader := []byte("Exif\x00\x00")
+	offset := 2
+	for offset+4 <= len(raw) {
+		if raw[offset] != 0xFF {
+			return exifOrientationUnknown
+		}
+		marker := raw[offset+1]
+		// Skip fill bytes.
+		if marker == 0xFF {
+			offset++
+			continue
+		}
+		// Start of scan or end of image. EXIF data must come before.
+		if marker == 0xDA || marker == 0xD9 {
+			return exifOrientationUnknown
+		}
+		segmentLen := int(binary.BigEndian.Uint16(raw[offset+2 : offset+4]))
+		segmentStart := offset + 4
+		segmentEnd := offset + 2 + segmentLen
+		if segmentLen < 2 || segmentEnd > len(raw) {
+			return exifOrientationUnknown
+		}
+		if marker == 0xE1 && bytes.HasPrefix(raw[segmentStart:segmentEnd], exifHeader) {
+			return readTIFFOrientation(raw[segmentStart+len(exifHeader) : segmentEnd])
+		}
+		offset = segmentEnd
+	}
+	return exifOrientationUnknown
+}
+
+// readWebPEXIFOrientation searches the RIFF chunks of a WebP file for an EXIF
+// chunk.
+func readWebPEXIFOrientation(raw []byte) exifOrientation {
+	offset := 12
+	for offset+8 <= len(raw) {
+		chunkID := string(raw[offset : offset+4])
+		chunkLen := int(binary.LittleEndian.Uint32(raw[offset+4 : offset+8]))
+		chunkStart := offset + 8
+		chunkEnd := chunkStart + chunkLen
+		if chunkLen < 0 || chunkEnd > len(raw) {
+			return exifOrientationUnknown
+		}
+		if chunkID == "EXIF" {
+			chunk := bytes.TrimPrefix(raw[chunkStart:chunkEnd], []byte("Exif\x00\x00"))
+			return readTIFFOrientation(chunk)
+		}
+		// Chunks are padded to even sizes.
+		offset = chunkEnd + chunkLen%2
+	}
+	return exifOrientationUnknown
+}
+
+// readTIFFOrientation reads the orientation tag from the first IFD of the given
+// TIFF-structured data.
+func readTIFFOrientation(tiff []byte) exifOrientation {
+	if len(tiff) < 8 {
+		return exifOrientationUnknown
+	}
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return exifOrientationUnknown
+	}
+	if order.Uint16(tiff[2:4]) != 42 {
+		return exifOrientationUnknown
+	}
+	ifdOffset := int(order.Uint32(tiff[4:8]))
+	if ifdOffset < 8 || ifdOffset+2 > len(tiff) {
+		return exifOrientationUnknown
+	}
+	entryCount := int(order.Uint16(tiff[ifdOffset : ifdOffset+2]))
+	for i := 0; i < entryCount; i++ {
+		entryStart := ifdOffset + 2 + i*12
+		if entryStart+12 > len(tiff) {
+			return exifOrientationUnknown
+		}
+		entry := tiff[entryStart : entryStart+12]
+		if order.Uint16(entry[0:2]) != exifTagOrientation {
+			continue
+		}
+		// Orientation is of type SHORT which is stored left-aligned in the value field.
+		const tiffTypeShort = 3
+		if order.Uint16(entry[2:4]) != tiffTypeShort {
+			return exifOrientationUnknown
+		}
+		orientation := exifOrientation(order.Uint16(entry[8:10]))
+		if orientation < exifOrientationNormal || orientation > exifOrientationRotate90 {
+			return exifOrientationUnknown
+		}
+		return orientation
+	}
+	return exifOrientationUnknown
+}
+
+// applyEXIFOrientation rotates and mirrors the given image so that it is
+// displayed as intended by the given orientation.
+func applyEXIFOrientation(img image.Image, orientation exifOrientation) image.Image {
+	var filter gift.Filter
+	switch orientation {
+	case exifOrientationFlipH:
+		filter = gift.FlipHorizontal()
+	case exifOrientationRotate180:
+		filter = gift.Rotate180()
+	case exifOrientationFlipV:
+		filter = gift.FlipVertical()
+	case exifOrientationTranspose:
+		filter = gift.Transpose()
+	case exifOrientationRotate270:
+		filter = gift.Rotate270()
+	case exifOrientationTransverse:
+		filter = gift.Transverse()
+	case exifOrientationRotate90:
+		filter = gift.Rotate90()
+	default:
+		return img
+	}
+	g := gift.New(filter)
+	dst := image.NewRGBA(g.Bounds(img.Bounds()))
+	g.Draw(dst, img)
+	return dst
+}
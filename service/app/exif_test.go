@@ -0,0 +1,113 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// tiffWithOrientation builds a minimal TIFF structure with a single IFD
+// containing the orientation tag.
+func tiffWithOrientation(order binary.ByteOrder, orientation uint16) []byte {
+	var b bytes.Buffer
+	if order == binary.LittleEndian {
+		b.WriteString("II")
+	} else {
+		b.WriteString("MM")
+	}
+	_ = binary.Write(&b, order, uint16(42))
+	_ = binary.Write(&b, order, uint32(8))
+	_ = binary.Write(&b, order, uint16(1))
+	_ = binary.Write(&b, order, uint16(exifTagOrientation))
+	_ = binary.Write(&b, order, uint16(3))
+	_ = binary.Write(&b, order, uint32(1))
+	_ = binary.Write(&b, order, orientation)
+	_ = binary.Write(&b, order, uint16(0))
+	_ = binary.Write(&b, order, uint32(0))
+	return b.Bytes()
+}
+
+// jpegWithOrientation encodes a JPEG and inserts an APP1 segment with the given
+// orientation directly after SOI.
+func jpegWithOrientation(t *testing.T, order binary.ByteOrder, orientation uint16) []byte {
+	var encoded bytes.Buffer
+	err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 4, 2)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app1 := append([]byte("Exif\x00\x00"), tiffWithOrientation(order, orientation)...)
+	var b bytes.Buffer
+	b.Write(encoded.Bytes()[:2])
+	b.Write([]byte{0xFF, 0xE1})
+	_ = binary.Write(&b, binary.BigEndian, uint16(len(app1)+2))
+	b.Write(app1)
+	b.Write(encoded.Bytes()[2:])
+	return b.Bytes()
+}
+
+func Test_readEXIFOrientation(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    func(t *testing.T) []byte
+		expect exifOrientation
+	}{
+		{
+			name:   "empty",
+			raw:    func(_ *testing.T) []byte { return nil },
+			expect: exifOrientationUnknown,
+		},
+		{
+			name: "jpeg without exif",
+			raw: func(t *testing.T) []byte {
+				var b bytes.Buffer
+				err := jpeg.Encode(&b, image.NewGray(image.Rect(0, 0, 4, 2)), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return b.Bytes()
+			},
+			expect: exifOrientationUnknown,
+		},
+		{
+			name:   "jpeg big endian",
+			raw:    func(t *testing.T) []byte { return jpegWithOrientation(t, binary.BigEndian, 6) },
+			expect: exifOrientationRotate270,
+		},
+		{
+			name:   "jpeg little endian",
+			raw:    func(t *testing.T) []byte { return jpegWithOrientation(t, binary.LittleEndian, 8) },
+			expect: exifOrientationRotate90,
+		},
+		{
+			name:   "jpeg invalid orientation",
+			raw:    func(t *testing.T) []byte { return jpegWithOrientation(t, binary.LittleEndian, 42) },
+			expect: exifOrientationUnknown,
+		},
+		{
+			name:   "tiff",
+			raw:    func(_ *testing.T) []byte { return tiffWithOrientation(binary.LittleEndian, 3) },
+			expect: exifOrientationRotate180,
+		},
+		{
+			name:   "truncated tiff",
+			raw:    func(_ *testing.T) []byte { return tiffWithOrientation(binary.LittleEndian, 3)[:12] },
+			expect: exifOrientationUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expect, readEXIFOrientation(tt.raw(t)))
+		})
+	}
+}
+
+func Test_applyEXIFOrientation(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 4, 2))
+	assert.Equal(t, img.Bounds(), applyEXIFOrientation(img, exifOrientationUnknown).Bounds())
+	assert.Equal(t, img.Bounds(), applyEXIFOrientation(img, exifOrientationRotate180).Bounds())
+	assert.Equal(t, image.Rect(0, 0, 2, 4), applyEXIFOrientation(img, exifOrientationRotate270).Bounds())
+}
@@ -0,0 +1,138 @@
+package app
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Grayscale conversion methods. They determine which pixels potrace considers
+// to be dark.
+const (
+	// grayscaleMethodAverage keeps the image as is, so that potrace uses the mean
+	// of the red, green and blue channel.
+	grayscaleMethodAverage = "average"
+	// grayscaleMethodLuminance uses Rec. 709 luminance.
+	grayscaleMethodLuminance = "luminance"
+	grayscaleMethodRed       = "red"
+	grayscaleMethodGreen     = "green"
+	grayscaleMethodBlue      = "blue"
+	grayscaleMethodMax       = "max"
+	grayscaleMethodMin       = "min"
+	// grayscaleMethodHue makes all pixels within a hue range black and all others
+	// white.
+	grayscaleMethodHue = "hue"
+)
+
+// defaultGrayscaleMethod is used if no grayscale method is requested.
+const defaultGrayscaleMethod = grayscaleMethodAverage
+
+var allowedGrayscaleMethods = []string{
+	grayscaleMethodAverage,
+	grayscaleMethodLuminance,
+	grayscaleMethodRed,
+	grayscaleMethodGreen,
+	grayscaleMethodBlue,
+	grayscaleMethodMax,
+	grayscaleMethodMin,
+	grayscaleMethodHue,
+}
+
+// grayscaleOptions configures how an image is converted to grayscale before
+// thresholding.
+type grayscaleOptions struct {
+	// Method is one of allowedGrayscaleMethods.
+	Method string
+	// HueMin is the start of the hue range in degrees for grayscaleMethodHue.
+	HueMin float64
+	// HueMax is the end of the hue range in degrees for grayscaleMethodHue. If it
+	// is less than HueMin, the range wraps around 360 degrees.
+	HueMax float64
+	// HueMinSaturation is the saturation from 0 to 1 that pixels need at least for
+	// being considered to be within the hue range. Without it, the hue of gray
+	// pixels would be arbitrary.
+	HueMinSaturation float64
+}
+
+// convertGrayscale converts the given image to grayscale with the method from
+// grayscaleOptions.
+func convertGrayscale(img image.Image, options grayscaleOptions) image.Image {
+	var convert func(c color.RGBA) uint8
+	switch options.Method {
+	case grayscaleMethodLuminance:
+		convert = func(c color.RGBA) uint8 {
+			return uint8(math.Round(0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)))
+		}
+	case grayscaleMethodRed:
+		convert = func(c color.RGBA) uint8 { return c.R }
+	case grayscaleMethodGreen:
+		convert = func(c color.RGBA) uint8 { return c.G }
+	case grayscaleMethodBlue:
+		convert = func(c color.RGBA) uint8 { return c.B }
+	case grayscaleMethodMax:
+		convert = func(c color.RGBA) uint8 { return max(c.R, c.G, c.B) }
+	case grayscaleMethodMin:
+		convert = func(c color.RGBA) uint8 { return min(c.R, c.G, c.B) }
+	case grayscaleMethodHue:
+		convert = func(c color.RGBA) uint8 {
+			if options.inHueRange(c) {
+				return 0
+			}
+			return math.MaxUint8
+		}
+	default:
+		return img
+	}
+	src := toRGBA(img)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	gray := image.NewGray(src.Bounds())
+	parallelRows(height, func(minY, maxY int) {
+		for y := minY; y < maxY; y++ {
+			srcRow := src.Pix[y*src.Stride : y*src.Stride+4*width]
+			grayRow := gray.Pix[y*gray.Stride : y*gray.Stride+width]
+			for x := range grayRow {
+				grayRow[x] = convert(color.RGBA{R: srcRow[4*x], G: srcRow[4*x+1], B: srcRow[4*x+2], A: srcRow[4*x+3]})
+			}
+		}
+	})
+	return gray
+}
+
+// inHueRange checks whether the given color is within the configured hue range
+// and saturated enough.
+func (options grayscaleOptions) inHueRange(c color.RGBA) bool {
+	hue, saturation := hueSaturation(c)
+	if saturation < options.HueMinSaturation || saturation == 0 {
+		return false
+	}
+	if options.HueMin <= options.HueMax {
+		return hue >= options.HueMin && hue <= options.HueMax
+	}
+	return hue >= options.HueMin || hue <= options.HueMax
+}
+
+// hueSaturation returns the HSV hue in degrees from 0 to 360 and the saturation
+// from 0 to 1 of the given color.
+func hueSaturation(c color.RGBA) (float64, float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	maxC := max(r, g, b)
+	minC := min(r, g, b)
+	delta := maxC - minC
+	if delta == 0 {
+		return 0, 0
+	}
+	var hue float64
+	switch maxC {
+	case r:
+		hue = math.Mod((g-b)/delta, 6)
+	case g:
+		hue = (b-r)/delta + 2
+	default:
+		hue = (r-g)/delta + 4
+	}
+	hue *= 60
+	if hue < 0 {
+		hue += 360
+	}
+	return hue, delta / maxC
+}
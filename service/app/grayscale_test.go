@@ -0,0 +1,58 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_hueSaturation(t *testing.T) {
+	tests := []struct {
+		c          color.RGBA
+		hue        float64
+		saturation float64
+	}{
+		{c: color.RGBA{R: 255, A: 255}, hue: 0, saturation: 1},
+		{c: color.RGBA{G: 255, A: 255}, hue: 120, saturation: 1},
+		{c: color.RGBA{B: 255, A: 255}, hue: 240, saturation: 1},
+		{c: color.RGBA{R: 255, B: 255, A: 255}, hue: 300, saturation: 1},
+		{c: color.RGBA{R: 255, G: 128, B: 128, A: 255}, hue: 0, saturation: 127.0 / 255},
+		{c: color.RGBA{R: 100, G: 100, B: 100, A: 255}, hue: 0, saturation: 0},
+	}
+	for _, tt := range tests {
+		hue, saturation := hueSaturation(tt.c)
+		assert.InDelta(t, tt.hue, hue, 1e-9, "hue of %v", tt.c)
+		assert.InDelta(t, tt.saturation, saturation, 1e-9, "saturation of %v", tt.c)
+	}
+}
+
+func Test_convertGrayscale(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	img.SetRGBA(0, 0, color.RGBA{R: 220, G: 20, B: 20, A: 255})
+	img.SetRGBA(1, 0, color.RGBA{R: 20, G: 20, B: 220, A: 255})
+	img.SetRGBA(2, 0, color.RGBA{R: 128, G: 128, B: 128, A: 255})
+
+	grayAt := func(img image.Image, x int) uint8 {
+		return color.GrayModel.Convert(img.At(x, 0)).(color.Gray).Y
+	}
+
+	t.Run("average keeps image", func(t *testing.T) {
+		assert.Same(t, img, convertGrayscale(img, grayscaleOptions{Method: grayscaleMethodAverage}))
+	})
+
+	t.Run("red channel", func(t *testing.T) {
+		gray := convertGrayscale(img, grayscaleOptions{Method: grayscaleMethodRed})
+		assert.Equal(t, []uint8{220, 20, 128}, []uint8{grayAt(gray, 0), grayAt(gray, 1), grayAt(gray, 2)})
+	})
+
+	t.Run("hue range wrapping around", func(t *testing.T) {
+		gray := convertGrayscale(img, grayscaleOptions{
+			Method:           grayscaleMethodHue,
+			HueMin:           330,
+			HueMax:           30,
+			HueMinSaturation: 0.2,
+		})
+		assert.Equal(t, []uint8{0, 255, 255}, []uint8{grayAt(gray, 0), grayAt(gray, 1), grayAt(gray, 2)})
+	})
+}
@@ -0,0 +1,279 @@
+package app
+
+import (
+	"fmt"
+	"github.com/lefinal/meh"
+	"image"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Morphological operations. They work on the image thresholded with the trace
+// black level, where dilation grows the dark ink. Inverting is not taken into
+// account.
+const (
+	morphologyOpDilate    = "dilate"
+	morphologyOpErode     = "erode"
+	morphologyOpOpen      = "open"
+	morphologyOpClose     = "close"
+	morphologyOpFillHoles = "fill_holes"
+)
+
+var allowedMorphologyOps = []string{
+	morphologyOpDilate,
+	morphologyOpErode,
+	morphologyOpOpen,
+	morphologyOpClose,
+	morphologyOpFillHoles,
+}
+
+const (
+	morphologyKernelSquare = "square"
+	morphologyKernelDisk   = "disk"
+	morphologyKernelCross  = "cross"
+)
+
+var allowedMorphologyKernels = []string{morphologyKernelSquare, morphologyKernelDisk, morphologyKernelCross}
+
+// maxMorphologyRadius limits the kernel radius for morphological operations.
+const maxMorphologyRadius = 50
+
+// morphologyStep is a single morphological operation.
+type morphologyStep struct {
+	// Op is one of allowedMorphologyOps.
+	Op string
+	// Radius of the kernel. Ignored for morphologyOpFillHoles.
+	Radius int
+}
+
+// morphologyOptions holds the morphological operations to apply in order.
+type morphologyOptions struct {
+	Steps []morphologyStep
+	// Kernel is one of allowedMorphologyKernels.
+	Kernel string
+}
+
+// parseMorphologySteps parses a comma-separated list of morphological
+// operations. Each operation may be suffixed with ":<radius>" for overriding the
+// given default radius, e.g. "close:2,fill_holes,dilate".
+func parseMorphologySteps(s string, defaultRadius int) ([]morphologyStep, error) {
+	steps := make([]morphologyStep, 0)
+	for _, stepStr := range strings.Split(s, ",") {
+		stepStr = strings.TrimSpace(stepStr)
+		if stepStr == "" {
+			continue
+		}
+		op, radiusStr, hasRadius := strings.Cut(stepStr, ":")
+		if !slices.Contains(allowedMorphologyOps, op) {
+			return nil, meh.NewBadInputErr(fmt.Sprintf("unsupported morphology operation: %s", op),
+				meh.Details{"allowed": allowedMorphologyOps})
+		}
+		step := morphologyStep{
+			Op:     op,
+			Radius: defaultRadius,
+		}
+		if hasRadius {
+			radius, err := strconv.Atoi(radiusStr)
+			if err != nil {
+				return nil, meh.NewBadInputErrFromErr(err, "parse morphology radius", meh.Details{"was": radiusStr})
+			}
+			step.Radius = radius
+		}
+		step.Radius = min(step.Radius, maxMorphologyRadius)
+		step.Radius = max(step.Radius, 1)
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// applyMorphology thresholds the given image with the given black level like
+// potrace does and applies the morphological operations to the bitmap. The
+// result has black ink on white.
+func applyMorphology(img image.Image, options morphologyOptions, blackLevel float64) *image.Gray {
+	// On a bilevel image, the minimum and maximum filters are exactly binary
+	// dilation and erosion.
+	gray := thresholdBitmap(img, blackLevel, false).gray()
+	for _, step := range options.Steps {
+		switch step.Op {
+		case morphologyOpDilate:
+			gray = morphologyFilter(gray, options.Kernel, step.Radius, true)
+		case morphologyOpErode:
+			gray = morphologyFilter(gray, options.Kernel, step.Radius, false)
+		case morphologyOpOpen:
+			// Removes ink smaller than the kernel.
+			gray = morphologyFilter(gray, options.Kernel, step.Radius, false)
+			gray = morphologyFilter(gray, options.Kernel, step.Radius, true)
+		case morphologyOpClose:
+			// Closes gaps in ink smaller than the kernel.
+			gray = morphologyFilter(gray, options.Kernel, step.Radius, true)
+			gray = morphologyFilter(gray, options.Kernel, step.Radius, false)
+		case morphologyOpFillHoles:
+			gray = fillHoles(gray)
+		}
+	}
+	return gray
+}
+
+// kernelRowHalfWidths returns the half-width of the kernel for each row offset
+// from -radius to radius.
+func kernelRowHalfWidths(kernel string, radius int) []int {
+	halfWidths := make([]int, 2*radius+1)
+	for dy := -radius; dy <= radius; dy++ {
+		switch kernel {
+		case morphologyKernelDisk:
+			halfWidths[dy+radius] = int(math.Floor(math.Sqrt(float64(radius*radius - dy*dy))))
+		case morphologyKernelCross:
+			if dy == 0 {
+				halfWidths[dy+radius] = radius
+			}
+		default:
+			halfWidths[dy+radius] = radius
+		}
+	}
+	return halfWidths
+}
+
+// morphologyFilter computes the minimum (dilation of ink) or maximum (erosion of
+// ink) over the kernel for each pixel. Kernels are decomposed into horizontal
+// runs, so that the cost is linear in the kernel radius.
+func morphologyFilter(gray *image.Gray, kernel string, radius int, dilateInk bool) *image.Gray {
+	bounds := gray.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	halfWidths := kernelRowHalfWidths(kernel, radius)
+	// Compute horizontal extremes for each distinct half-width only once.
+	rowExtremes := make(map[int][]uint8)
+	for _, halfWidth := range halfWidths {
+		if _, ok := rowExtremes[halfWidth]; !ok {
+			rowExtremes[halfWidth] = horizontalExtremes(gray, halfWidth, dilateInk)
+		}
+	}
+	dst := image.NewGray(bounds)
+	for y := 0; y < height; y++ {
+		dstRow := dst.Pix[y*dst.Stride : y*dst.Stride+width]
+		if dilateInk {
+			for x := range dstRow {
+				dstRow[x] = math.MaxUint8
+			}
+		}
+		for dy := -radius; dy <= radius; dy++ {
+			srcY := y + dy
+			if srcY < 0 || srcY >= height {
+				continue
+			}
+			srcRow := rowExtremes[halfWidths[dy+radius]][srcY*width : (srcY+1)*width]
+			for x, v := range srcRow {
+				if dilateInk {
+					dstRow[x] = min(dstRow[x], v)
+				} else {
+					dstRow[x] = max(dstRow[x], v)
+				}
+			}
+		}
+	}
+	return dst
+}
+
+// horizontalExtremes computes the minimum or maximum over a horizontal window
+// of 2*halfWidth+1 pixels for each pixel using the van Herk/Gil-Werman
+// algorithm. Pixels outside the image are ignored.
+func horizontalExtremes(gray *image.Gray, halfWidth int, useMin bool) []uint8 {
+	bounds := gray.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	out := make([]uint8, width*height)
+	pick := func(a, b uint8) uint8 {
+		if useMin {
+			return min(a, b)
+		}
+		return max(a, b)
+	}
+	// Pad rows with the neutral element so that each window has full size.
+	var neutral uint8
+	if useMin {
+		neutral = math.MaxUint8
+	}
+	windowSize := 2*halfWidth + 1
+	padded := make([]uint8, width+2*halfWidth)
+	for x := range halfWidth {
+		padded[x] = neutral
+		padded[len(padded)-1-x] = neutral
+	}
+	prefix := make([]uint8, len(padded))
+	suffix := make([]uint8, len(padded))
+	for y := 0; y < height; y++ {
+		copy(padded[halfWidth:], gray.Pix[y*gray.Stride:y*gray.Stride+width])
+		// Prefix and suffix extremes within blocks of the window size.
+		for x := range padded {
+			if x%windowSize == 0 {
+				prefix[x] = padded[x]
+			} else {
+				prefix[x] = pick(prefix[x-1], padded[x])
+			}
+		}
+		for x := len(padded) - 1; x >= 0; x-- {
+			if x == len(padded)-1 || (x+1)%windowSize == 0 {
+				suffix[x] = padded[x]
+			} else {
+				suffix[x] = pick(suffix[x+1], padded[x])
+			}
+		}
+		// The window for pixel x spans x to x+2*halfWidth in padded coordinates.
+		outRow := out[y*width : (y+1)*width]
+		for x := range outRow {
+			outRow[x] = pick(suffix[x], prefix[x+windowSize-1])
+		}
+	}
+	return out
+}
+
+// fillHoles fills light regions that are completely enclosed by darker ones.
+// This is done via morphological reconstruction from the image border: each
+// pixel gets the highest value of all paths to the border, where the value of
+// a path is its darkest pixel. Light regions without a path to the border are
+// thereby lowered to the value of their enclosing ring.
+func fillHoles(gray *image.Gray) *image.Gray {
+	bounds := gray.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	dst := image.NewGray(bounds)
+	if width == 0 || height == 0 {
+		return dst
+	}
+	visited := make([]bool, width*height)
+	// Bucket queue for processing pixels with the highest value first.
+	buckets := make([][]int, math.MaxUint8+1)
+	push := func(x, y int, v uint8) {
+		i := y*width + x
+		if visited[i] {
+			return
+		}
+		visited[i] = true
+		dst.Pix[y*dst.Stride+x] = v
+		buckets[v] = append(buckets[v], i)
+	}
+	for x := 0; x < width; x++ {
+		push(x, 0, gray.Pix[x])
+		push(x, height-1, gray.Pix[(height-1)*gray.Stride+x])
+	}
+	for y := 0; y < height; y++ {
+		push(0, y, gray.Pix[y*gray.Stride])
+		push(width-1, y, gray.Pix[y*gray.Stride+width-1])
+	}
+	for level := math.MaxUint8; level >= 0; level-- {
+		// Pushing during iteration only appends to buckets at or below the current
+		// level.
+		for len(buckets[level]) > 0 {
+			i := buckets[level][len(buckets[level])-1]
+			buckets[level] = buckets[level][:len(buckets[level])-1]
+			x, y := i%width, i/width
+			for _, d := range [4][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+				nx, ny := x+d[0], y+d[1]
+				if nx < 0 || nx >= width || ny < 0 || ny >= height {
+					continue
+				}
+				push(nx, ny, min(uint8(level), gray.Pix[ny*gray.Stride+nx]))
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image"
+	"math/rand"
+	"testing"
+)
+
+// naiveMorphologyFilter is the reference implementation for morphologyFilter.
+func naiveMorphologyFilter(gray *image.Gray, kernel string, radius int, dilateInk bool) *image.Gray {
+	bounds := gray.Bounds()
+	halfWidths := kernelRowHalfWidths(kernel, radius)
+	dst := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			v := gray.GrayAt(x, y).Y
+			for dy := -radius; dy <= radius; dy++ {
+				for dx := -halfWidths[dy+radius]; dx <= halfWidths[dy+radius]; dx++ {
+					p := image.Pt(x+dx, y+dy)
+					if !p.In(bounds) {
+						continue
+					}
+					if dilateInk {
+						v = min(v, gray.GrayAt(p.X, p.Y).Y)
+					} else {
+						v = max(v, gray.GrayAt(p.X, p.Y).Y)
+					}
+				}
+			}
+			dst.Pix[dst.PixOffset(x, y)] = v
+		}
+	}
+	return dst
+}
+
+func Test_morphologyFilter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	gray := image.NewGray(image.Rect(0, 0, 23, 17))
+	rng.Read(gray.Pix)
+
+	for _, kernel := range allowedMorphologyKernels {
+		for _, radius := range []int{1, 2, 5, 30} {
+			for _, dilateInk := range []bool{true, false} {
+				expect := naiveMorphologyFilter(gray, kernel, radius, dilateInk)
+				got := morphologyFilter(gray, kernel, radius, dilateInk)
+				require.Equal(t, expect.Pix, got.Pix, "kernel %s, radius %d, dilate ink %v", kernel, radius, dilateInk)
+			}
+		}
+	}
+}
+
+func Test_fillHoles(t *testing.T) {
+	// White background with a black ring around a white hole.
+	gray := image.NewGray(image.Rect(0, 0, 7, 7))
+	for i := range gray.Pix {
+		gray.Pix[i] = 255
+	}
+	for i := 1; i <= 5; i++ {
+		gray.Pix[gray.PixOffset(i, 1)] = 0
+		gray.Pix[gray.PixOffset(i, 5)] = 0
+		gray.Pix[gray.PixOffset(1, i)] = 0
+		gray.Pix[gray.PixOffset(5, i)] = 0
+	}
+	gray.Pix[gray.PixOffset(3, 3)] = 100
+
+	filled := fillHoles(gray)
+
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 7; x++ {
+			if x == 0 || y == 0 || x == 6 || y == 6 {
+				assert.Equal(t, uint8(255), filled.GrayAt(x, y).Y, "background (%d,%d) should stay white", x, y)
+			} else {
+				assert.Equal(t, uint8(0), filled.GrayAt(x, y).Y, "(%d,%d) should be filled", x, y)
+			}
+		}
+	}
+}
+
+func Test_applyMorphology(t *testing.T) {
+	options := morphologyOptions{Kernel: morphologyKernelSquare}
+	// newGray returns a white image with the given rows of intensities starting
+	// at the top left.
+	newGray := func(rows [][]uint8) *image.Gray {
+		gray := image.NewGray(image.Rect(0, 0, 7, 7))
+		for i := range gray.Pix {
+			gray.Pix[i] = 255
+		}
+		for y, row := range rows {
+			copy(gray.Pix[y*gray.Stride:], row)
+		}
+		return gray
+	}
+
+	t.Run("fill holes ignores gray levels", func(t *testing.T) {
+		// Anti-aliased ring around a hole that is lighter than the threshold, but
+		// darker than the background.
+		gray := newGray([][]uint8{
+			{255, 255, 255, 255, 255},
+			{255, 100, 100, 100, 255},
+			{255, 100, 200, 100, 255},
+			{255, 100, 100, 100, 255},
+		})
+		options := options
+		options.Steps = []morphologyStep{{Op: morphologyOpFillHoles}}
+		got := applyMorphology(gray, options, .5)
+		assert.Equal(t, uint8(0), got.GrayAt(2, 2).Y, "hole should be filled with ink")
+		assert.Equal(t, uint8(0), got.GrayAt(1, 1).Y, "ring should be ink")
+		assert.Equal(t, uint8(255), got.GrayAt(0, 0).Y, "background should stay white")
+	})
+
+	t.Run("close bridges light gaps", func(t *testing.T) {
+		// Two ink pixels with a gap that is darker than the background, but lighter
+		// than the threshold.
+		gray := newGray([][]uint8{
+			{255, 255, 255, 255, 255},
+			{255, 0, 200, 0, 255},
+		})
+		options := options
+		options.Steps = []morphologyStep{{Op: morphologyOpClose, Radius: 1}}
+		got := applyMorphology(gray, options, .5)
+		assert.Equal(t, uint8(0), got.GrayAt(2, 1).Y, "gap should be closed")
+		assert.True(t, isBilevel(got))
+	})
+
+	t.Run("black level", func(t *testing.T) {
+		gray := newGray([][]uint8{{100}})
+		got := applyMorphology(gray, options, .3)
+		assert.Equal(t, uint8(255), got.GrayAt(0, 0).Y, "pixel should be background")
+	})
+}
+
+func Test_parseMorphologySteps(t *testing.T) {
+	steps, err := parseMorphologySteps("close:3, fill_holes,dilate:0", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []morphologyStep{
+		{Op: morphologyOpClose, Radius: 3},
+		{Op: morphologyOpFillHoles, Radius: 2},
+		{Op: morphologyOpDilate, Radius: 1},
+	}, steps)
+
+	_, err = parseMorphologySteps("shrink", 1)
+	assert.Error(t, err)
+	_, err = parseMorphologySteps("dilate:x", 1)
+	assert.Error(t, err)
+}
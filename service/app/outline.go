@@ -0,0 +1,940 @@
+package app
+
+import (
+	"go.uber.org/zap"
+	"math"
+	"time"
+)
+
+// The outline tracer is a port of the potrace algorithm by Peter Selinger, see
+// https://potrace.sourceforge.net/potrace.pdf. Paths from decomposeOutlines are
+// approximated by an optimal polygon, whose corners are then smoothed into
+// Bézier curves. Finally, consecutive curves are joined where possible.
+
+// outlineSegment is a segment of an outline curve. Corners are drawn as two
+// lines from the previous end point via C[1] to C[2]. Other segments are cubic
+// Bézier curves with the control points C[0] and C[1] and the end point C[2].
+type outlineSegment struct {
+	Corner bool
+	C      [3]point
+}
+
+// outlineCurve is a closed curve. The start point is the end point of the last
+// segment.
+type outlineCurve struct {
+	Segments []outlineSegment
+	Hole     bool
+}
+
+// outlineTraceOptions are the options for traceOutlines. They match those of
+// potrace.
+type outlineTraceOptions struct {
+	// TurnPolicy is one of allowedTraceTurnPolicies.
+	TurnPolicy string
+	// TurdSize is the maximum area of paths that are dropped.
+	TurdSize int
+	// AlphaMax is the corner threshold. Higher values result in smoother curves.
+	AlphaMax float64
+	// OptimizeCurves enables joining consecutive curves.
+	OptimizeCurves bool
+	// OptimizationTolerance is the maximum deviation when joining curves.
+	OptimizationTolerance float64
+}
+
+// traceOutlines traces the outlines of all ink components and their holes in
+// the given bitmap. Coordinates have the y-axis pointing up.
+func traceOutlines(bm *bitmap, options outlineTraceOptions) []outlineCurve {
+	paths := decomposeOutlines(bm, options.TurnPolicy, options.TurdSize)
+	curves := make([]outlineCurve, 0, len(paths))
+	for _, path := range paths {
+		curves = append(curves, traceOutlinePath(path, options))
+	}
+	return curves
+}
+
+// traceOutlinePath approximates a single path with a curve.
+func traceOutlinePath(path outlinePath, options outlineTraceOptions) outlineCurve {
+	pp := newOutlinePolygonPath(path)
+	pp.calcLon()
+	pp.bestPolygon()
+	curve := pp.adjustVertices()
+	if path.hole {
+		curve.reverse()
+	}
+	curve.smooth(options.AlphaMax)
+	if options.OptimizeCurves {
+		curve = curve.optimize(options.OptimizationTolerance)
+	}
+	return outlineCurve{
+		Segments: curve.segments(),
+		Hole:     path.hole,
+	}
+}
+
+// outlineSums holds prefix sums of point coordinates for fast calculation of
+// line fit errors.
+type outlineSums struct {
+	x, y, x2, xy, y2 float64
+}
+
+// outlinePolygonPath holds the intermediate results for finding the optimal
+// polygon of a path.
+type outlinePolygonPath struct {
+	pt []gridPoint
+	// x0 and y0 are the origin for sums.
+	x0, y0 int
+	sums   []outlineSums
+	// lon holds for each point the furthest point that can be reached with a
+	// straight line.
+	lon []int
+	// po holds the indices of the polygon corners.
+	po []int
+}
+
+func newOutlinePolygonPath(path outlinePath) *outlinePolygonPath {
+	n := len(path.points)
+	pp := &outlinePolygonPath{
+		pt:   path.points,
+		x0:   path.points[0].X,
+		y0:   path.points[0].Y,
+		sums: make([]outlineSums, n+1),
+	}
+	for i, p := range pp.pt {
+		x := float64(p.X - pp.x0)
+		y := float64(p.Y - pp.y0)
+		pp.sums[i+1] = outlineSums{
+			x:  pp.sums[i].x + x,
+			y:  pp.sums[i].y + y,
+			x2: pp.sums[i].x2 + x*x,
+			xy: pp.sums[i].xy + x*y,
+			y2: pp.sums[i].y2 + y*y,
+		}
+	}
+	return pp
+}
+
+// cyclicMod returns a modulo n in range [0, n).
+func cyclicMod(a, n int) int {
+	a %= n
+	if a < 0 {
+		a += n
+	}
+	return a
+}
+
+// floorDiv returns a/n rounded towards negative infinity for positive n.
+func floorDiv(a, n int) int {
+	if a >= 0 {
+		return a / n
+	}
+	return -1 - (-1-a)/n
+}
+
+// cyclic reports whether b lies in the cyclic range [a, c).
+func cyclic(a, b, c int) bool {
+	if a <= c {
+		return a <= b && b < c
+	}
+	return a <= b || b < c
+}
+
+func signInt(x int) int {
+	if x > 0 {
+		return 1
+	} else if x < 0 {
+		return -1
+	}
+	return 0
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func signFloat(x float64) int {
+	if x > 0 {
+		return 1
+	} else if x < 0 {
+		return -1
+	}
+	return 0
+}
+
+// gridCross returns the cross product of the given vectors.
+func gridCross(a, b gridPoint) int {
+	return a.X*b.Y - a.Y*b.X
+}
+
+// calcLon determines for each point the furthest point that can be reached with
+// a straight line in the sense of the potrace paper.
+func (pp *outlinePolygonPath) calcLon() {
+	pt := pp.pt
+	n := len(pt)
+	// nc points from each point to the furthest future point connected by a
+	// vertical or horizontal segment.
+	nc := make([]int, n)
+	k := 0
+	for i := n - 1; i >= 0; i-- {
+		if pt[i].X != pt[k].X && pt[i].Y != pt[k].Y {
+			k = i + 1
+		}
+		nc[i] = k
+	}
+
+	// pivk holds for each point the furthest point k such that all points in
+	// between lie on a straight line to it.
+	pivk := make([]int, n)
+	for i := n - 1; i >= 0; i-- {
+		var ct [4]int
+		next := pt[cyclicMod(i+1, n)]
+		ct[(3+3*(next.X-pt[i].X)+(next.Y-pt[i].Y))/2]++
+		var constraint [2]gridPoint
+		k := nc[i]
+		k1 := i
+		foundK := false
+		for {
+			ct[(3+3*signInt(pt[k].X-pt[k1].X)+signInt(pt[k].Y-pt[k1].Y))/2]++
+			// If all four directions have occurred, cut the path.
+			if ct[0] > 0 && ct[1] > 0 && ct[2] > 0 && ct[3] > 0 {
+				pivk[i] = k1
+				foundK = true
+				break
+			}
+			cur := gridPoint{X: pt[k].X - pt[i].X, Y: pt[k].Y - pt[i].Y}
+			if gridCross(constraint[0], cur) < 0 || gridCross(constraint[1], cur) > 0 {
+				break
+			}
+			if absInt(cur.X) > 1 || absInt(cur.Y) > 1 {
+				off := gridPoint{X: cur.X - 1, Y: cur.Y - 1}
+				if cur.Y >= 0 && (cur.Y > 0 || cur.X < 0) {
+					off.X = cur.X + 1
+				}
+				if cur.X <= 0 && (cur.X < 0 || cur.Y < 0) {
+					off.Y = cur.Y + 1
+				}
+				if gridCross(constraint[0], off) >= 0 {
+					constraint[0] = off
+				}
+				off = gridPoint{X: cur.X - 1, Y: cur.Y - 1}
+				if cur.Y <= 0 && (cur.Y < 0 || cur.X < 0) {
+					off.X = cur.X + 1
+				}
+				if cur.X >= 0 && (cur.X > 0 || cur.Y < 0) {
+					off.Y = cur.Y + 1
+				}
+				if gridCross(constraint[1], off) <= 0 {
+					constraint[1] = off
+				}
+			}
+			k1 = k
+			k = nc[k1]
+			if !cyclic(k, i, k1) {
+				break
+			}
+		}
+		if foundK {
+			continue
+		}
+		// k1 was the last corner satisfying the constraint and k the first one
+		// violating it. Find the last point between them that satisfies it.
+		dk := gridPoint{X: signInt(pt[k].X - pt[k1].X), Y: signInt(pt[k].Y - pt[k1].Y)}
+		cur := gridPoint{X: pt[k1].X - pt[i].X, Y: pt[k1].Y - pt[i].Y}
+		a := gridCross(constraint[0], cur)
+		b := gridCross(constraint[0], dk)
+		c := gridCross(constraint[1], cur)
+		d := gridCross(constraint[1], dk)
+		j := math.MaxInt32
+		if b < 0 {
+			j = floorDiv(a, -b)
+		}
+		if d > 0 {
+			j = min(j, floorDiv(-c, d))
+		}
+		pivk[i] = cyclicMod(k1+j, n)
+	}
+
+	// Clean up, so that lon[i] is the largest k such that for all i' with
+	// i <= i' < k, i' < k <= pivk[i'].
+	pp.lon = make([]int, n)
+	j := pivk[n-1]
+	pp.lon[n-1] = j
+	for i := n - 2; i >= 0; i-- {
+		if cyclic(i+1, pivk[i], j) {
+			j = pivk[i]
+		}
+		pp.lon[i] = j
+	}
+	for i := n - 1; cyclic(cyclicMod(i+1, n), j, pp.lon[i]); i-- {
+		pp.lon[i] = j
+	}
+}
+
+// sumsBetween returns the sums and the number of points from i to j. If j is
+// less than i, the range wraps around.
+func (pp *outlinePolygonPath) sumsBetween(i, j int) (outlineSums, float64) {
+	n := len(pp.pt)
+	r := 0
+	for j >= n {
+		j -= n
+		r++
+	}
+	for i >= n {
+		i -= n
+		r--
+	}
+	for j < 0 {
+		j += n
+		r--
+	}
+	for i < 0 {
+		i += n
+		r++
+	}
+	rf := float64(r)
+	s := outlineSums{
+		x:  pp.sums[j+1].x - pp.sums[i].x + rf*pp.sums[n].x,
+		y:  pp.sums[j+1].y - pp.sums[i].y + rf*pp.sums[n].y,
+		x2: pp.sums[j+1].x2 - pp.sums[i].x2 + rf*pp.sums[n].x2,
+		xy: pp.sums[j+1].xy - pp.sums[i].xy + rf*pp.sums[n].xy,
+		y2: pp.sums[j+1].y2 - pp.sums[i].y2 + rf*pp.sums[n].y2,
+	}
+	return s, float64(j + 1 - i + r*n)
+}
+
+// penalty returns the penalty of an edge from point i to j with i <= j <= n.
+func (pp *outlinePolygonPath) penalty(i, j int) float64 {
+	n := len(pp.pt)
+	s, k := pp.sumsBetween(i, j)
+	if j >= n {
+		j -= n
+	}
+	px := float64(pp.pt[i].X+pp.pt[j].X)/2 - float64(pp.pt[0].X)
+	py := float64(pp.pt[i].Y+pp.pt[j].Y)/2 - float64(pp.pt[0].Y)
+	ey := float64(pp.pt[j].X - pp.pt[i].X)
+	ex := -float64(pp.pt[j].Y - pp.pt[i].Y)
+
+	a := (s.x2-2*s.x*px)/k + px*px
+	b := (s.xy-s.x*py-s.y*px)/k + px*py
+	c := (s.y2-2*s.y*py)/k + py*py
+	return math.Sqrt(ex*ex*a + 2*ex*ey*b + ey*ey*c)
+}
+
+// bestPolygon finds the polygon with the fewest corners and, among those, the
+// smallest penalty.
+func (pp *outlinePolygonPath) bestPolygon() {
+	n := len(pp.pt)
+	pen := make([]float64, n+1)
+	prev := make([]int, n+1)
+	clip0 := make([]int, n)
+	clip1 := make([]int, n+1)
+	seg0 := make([]int, n+1)
+	seg1 := make([]int, n+1)
+
+	// Calculate clipped paths.
+	for i := 0; i < n; i++ {
+		c := cyclicMod(pp.lon[cyclicMod(i-1, n)]-1, n)
+		if c == i {
+			c = cyclicMod(i+1, n)
+		}
+		if c < i {
+			clip0[i] = n
+		} else {
+			clip0[i] = c
+		}
+	}
+
+	// Calculate backwards path clipping: j <= clip0[i] iff clip1[j] <= i.
+	j := 1
+	for i := 0; i < n; i++ {
+		for j <= clip0[i] {
+			clip1[j] = i
+			j++
+		}
+	}
+
+	// seg0[j] is the longest path from 0 with j segments.
+	i := 0
+	for j = 0; i < n; j++ {
+		seg0[j] = i
+		i = clip0[i]
+	}
+	seg0[j] = n
+	m := j
+
+	// seg1[j] is the longest path to n with m-j segments.
+	i = n
+	for j = m; j > 0; j-- {
+		seg1[j] = i
+		i = clip1[i]
+	}
+	seg1[0] = 0
+
+	// Find the path with m segments and the smallest penalty.
+	pen[0] = 0
+	for j := 1; j <= m; j++ {
+		for i := seg1[j]; i <= seg0[j]; i++ {
+			best := -1.0
+			for k := seg0[j-1]; k >= clip1[i]; k-- {
+				p := pp.penalty(k, i) + pen[k]
+				if best < 0 || p < best {
+					prev[i] = k
+					best = p
+				}
+			}
+			pen[i] = best
+		}
+	}
+
+	pp.po = make([]int, m)
+	for i, j := n, m-1; i > 0; j-- {
+		i = prev[i]
+		pp.po[j] = i
+	}
+}
+
+// pointSlope returns the center and direction of the line that best fits the
+// points from i to j.
+func (pp *outlinePolygonPath) pointSlope(i, j int) (point, point) {
+	s, k := pp.sumsBetween(i, j)
+	ctr := point{X: s.x / k, Y: s.y / k}
+	a := (s.x2 - s.x*s.x/k) / k
+	b := (s.xy - s.x*s.y/k) / k
+	c := (s.y2 - s.y*s.y/k) / k
+	// Larger eigenvalue.
+	lambda2 := (a + c + math.Sqrt((a-c)*(a-c)+4*b*b)) / 2
+	// Find the eigenvector for it.
+	a -= lambda2
+	c -= lambda2
+	var dir point
+	if math.Abs(a) >= math.Abs(c) {
+		if l := math.Sqrt(a*a + b*b); l != 0 {
+			dir = point{X: -b / l, Y: a / l}
+		}
+	} else {
+		if l := math.Sqrt(c*c + b*b); l != 0 {
+			dir = point{X: -c / l, Y: b / l}
+		}
+	}
+	return ctr, dir
+}
+
+// quadForm is a symmetric 3x3 matrix. The squared distance of a point (x, y)
+// from a line is (x, y, 1) Q (x, y, 1)^T.
+type quadForm [3][3]float64
+
+func (q quadForm) apply(w point) float64 {
+	v := [3]float64{w.X, w.Y, 1}
+	sum := 0.0
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			sum += v[i] * q[i][j] * v[j]
+		}
+	}
+	return sum
+}
+
+// outlinePolygonCurve is a curve based on the optimal polygon of a path.
+type outlinePolygonCurve struct {
+	corner []bool
+	c      [][3]point
+	vertex []point
+	alpha  []float64
+	beta   []float64
+}
+
+func newOutlinePolygonCurve(m int) *outlinePolygonCurve {
+	return &outlinePolygonCurve{
+		corner: make([]bool, m),
+		c:      make([][3]point, m),
+		vertex: make([]point, m),
+		alpha:  make([]float64, m),
+		beta:   make([]float64, m),
+	}
+}
+
+// adjustVertices moves the corners of the polygon within their pixel, so that
+// they best fit the adjacent edges.
+func (pp *outlinePolygonPath) adjustVertices() *outlinePolygonCurve {
+	m := len(pp.po)
+	n := len(pp.pt)
+	po := pp.po
+	x0, y0 := float64(pp.x0), float64(pp.y0)
+	curve := newOutlinePolygonCurve(m)
+
+	// Calculate the optimal point-slope representation of each edge and represent
+	// it as quadratic form.
+	q := make([]quadForm, m)
+	for i := 0; i < m; i++ {
+		j := po[cyclicMod(i+1, m)]
+		j = cyclicMod(j-po[i], n) + po[i]
+		ctr, dir := pp.pointSlope(po[i], j)
+		d := dir.X*dir.X + dir.Y*dir.Y
+		if d == 0 {
+			continue
+		}
+		v := [3]float64{dir.Y, -dir.X, 0}
+		v[2] = -v[1]*ctr.Y - v[0]*ctr.X
+		for l := 0; l < 3; l++ {
+			for k := 0; k < 3; k++ {
+				q[i][l][k] = v[l] * v[k] / d
+			}
+		}
+	}
+
+	// Find the point within the unit square around each corner that minimizes the
+	// squared distance to both adjacent edges.
+	for i := 0; i < m; i++ {
+		s := point{X: float64(pp.pt[po[i]].X) - x0, Y: float64(pp.pt[po[i]].Y) - y0}
+		j := cyclicMod(i-1, m)
+		var Q quadForm
+		for l := 0; l < 3; l++ {
+			for k := 0; k < 3; k++ {
+				Q[l][k] = q[j][l][k] + q[i][l][k]
+			}
+		}
+		var w point
+		for {
+			det := Q[0][0]*Q[1][1] - Q[0][1]*Q[1][0]
+			if det != 0 {
+				w.X = (-Q[0][2]*Q[1][1] + Q[1][2]*Q[0][1]) / det
+				w.Y = (Q[0][2]*Q[1][0] - Q[1][2]*Q[0][0]) / det
+				break
+			}
+			// The edges are parallel. Add an orthogonal axis through the center of the
+			// unit square.
+			var v [3]float64
+			if Q[0][0] > Q[1][1] {
+				v[0], v[1] = -Q[0][1], Q[0][0]
+			} else if Q[1][1] != 0 {
+				v[0], v[1] = -Q[1][1], Q[1][0]
+			} else {
+				v[0], v[1] = 1, 0
+			}
+			d := v[0]*v[0] + v[1]*v[1]
+			v[2] = -v[1]*s.Y - v[0]*s.X
+			for l := 0; l < 3; l++ {
+				for k := 0; k < 3; k++ {
+					Q[l][k] += v[l] * v[k] / d
+				}
+			}
+		}
+		if math.Abs(w.X-s.X) <= .5 && math.Abs(w.Y-s.Y) <= .5 {
+			curve.vertex[i] = point{X: w.X + x0, Y: w.Y + y0}
+			continue
+		}
+
+		// The minimum is not within the unit square. Minimize on its boundary
+		// instead.
+		minValue := Q.apply(s)
+		best := s
+		if Q[0][0] != 0 {
+			for z := 0; z < 2; z++ {
+				w.Y = s.Y - .5 + float64(z)
+				w.X = -(Q[0][1]*w.Y + Q[0][2]) / Q[0][0]
+				if v := Q.apply(w); math.Abs(w.X-s.X) <= .5 && v < minValue {
+					minValue = v
+					best = w
+				}
+			}
+		}
+		if Q[1][1] != 0 {
+			for z := 0; z < 2; z++ {
+				w.X = s.X - .5 + float64(z)
+				w.Y = -(Q[1][0]*w.X + Q[1][2]) / Q[1][1]
+				if v := Q.apply(w); math.Abs(w.Y-s.Y) <= .5 && v < minValue {
+					minValue = v
+					best = w
+				}
+			}
+		}
+		for l := 0; l < 2; l++ {
+			for k := 0; k < 2; k++ {
+				w = point{X: s.X - .5 + float64(l), Y: s.Y - .5 + float64(k)}
+				if v := Q.apply(w); v < minValue {
+					minValue = v
+					best = w
+				}
+			}
+		}
+		curve.vertex[i] = point{X: best.X + x0, Y: best.Y + y0}
+	}
+	return curve
+}
+
+// reverse reverses the orientation of the polygon.
+func (curve *outlinePolygonCurve) reverse() {
+	for i, j := 0, len(curve.vertex)-1; i < j; i, j = i+1, j-1 {
+		curve.vertex[i], curve.vertex[j] = curve.vertex[j], curve.vertex[i]
+	}
+}
+
+// interval returns the point at lambda on the line from a to b.
+func interval(lambda float64, a, b point) point {
+	return point{X: a.X + lambda*(b.X-a.X), Y: a.Y + lambda*(b.Y-a.Y)}
+}
+
+// dpara returns the signed area of the parallelogram spanned by p0p1 and p0p2.
+func dpara(p0, p1, p2 point) float64 {
+	return (p1.X-p0.X)*(p2.Y-p0.Y) - (p2.X-p0.X)*(p1.Y-p0.Y)
+}
+
+// ddenom calculates the denominator for the alpha parameter of a corner.
+func ddenom(p0, p2 point) float64 {
+	rX := -signFloat(p2.Y - p0.Y)
+	rY := signFloat(p2.X - p0.X)
+	return float64(rY)*(p2.X-p0.X) - float64(rX)*(p2.Y-p0.Y)
+}
+
+// cprod returns the cross product of p0p1 and p2p3.
+func cprod(p0, p1, p2, p3 point) float64 {
+	return (p1.X-p0.X)*(p3.Y-p2.Y) - (p3.X-p2.X)*(p1.Y-p0.Y)
+}
+
+// iprod returns the inner product of p0p1 and p0p2.
+func iprod(p0, p1, p2 point) float64 {
+	return (p1.X-p0.X)*(p2.X-p0.X) + (p1.Y-p0.Y)*(p2.Y-p0.Y)
+}
+
+// iprod1 returns the inner product of p0p1 and p2p3.
+func iprod1(p0, p1, p2, p3 point) float64 {
+	return (p1.X-p0.X)*(p3.X-p2.X) + (p1.Y-p0.Y)*(p3.Y-p2.Y)
+}
+
+// smooth replaces the corners of the polygon with curves, except for those
+// sharper than alphaMax.
+func (curve *outlinePolygonCurve) smooth(alphaMax float64) {
+	m := len(curve.vertex)
+	for i := 0; i < m; i++ {
+		j := cyclicMod(i+1, m)
+		k := cyclicMod(i+2, m)
+		p4 := interval(.5, curve.vertex[k], curve.vertex[j])
+		alpha := 4 / 3.0
+		if denom := ddenom(curve.vertex[i], curve.vertex[k]); denom != 0 {
+			dd := math.Abs(dpara(curve.vertex[i], curve.vertex[j], curve.vertex[k]) / denom)
+			alpha = 0
+			if dd > 1 {
+				alpha = 1 - 1/dd
+			}
+			alpha /= .75
+		}
+		if alpha >= alphaMax {
+			curve.corner[j] = true
+			curve.c[j][1] = curve.vertex[j]
+			curve.c[j][2] = p4
+		} else {
+			alpha = max(.55, min(alpha, 1))
+			curve.corner[j] = false
+			curve.c[j][0] = interval(.5+.5*alpha, curve.vertex[i], curve.vertex[j])
+			curve.c[j][1] = interval(.5+.5*alpha, curve.vertex[k], curve.vertex[j])
+			curve.c[j][2] = p4
+		}
+		curve.alpha[j] = alpha
+		curve.beta[j] = .5
+	}
+}
+
+// outlineOptimization is a candidate for joining the curves from i to j.
+type outlineOptimization struct {
+	pen   float64
+	c     [2]point
+	t, s  float64
+	alpha float64
+}
+
+// cos179 is the cosine of 179 degrees. Curves bending more than that are not
+// joined.
+var cos179 = math.Cos(179 * math.Pi / 180)
+
+// optimizationPenalty calculates the penalty for replacing the curves from i to
+// j with a single one. If this is not possible, false is returned.
+func (curve *outlinePolygonCurve) optimizationPenalty(i, j int, tolerance float64, convc []int, areac []float64) (outlineOptimization, bool) {
+	m := len(curve.vertex)
+	vertex := curve.vertex
+	// A full loop can never be joined.
+	if i == j {
+		return outlineOptimization{}, false
+	}
+
+	// Check convexity, absence of corners and the maximum bend.
+	i1 := cyclicMod(i+1, m)
+	k1 := i1
+	conv := convc[k1]
+	if conv == 0 {
+		return outlineOptimization{}, false
+	}
+	d := vertex[i].distance(vertex[i1])
+	for k := k1; k != j; k = k1 {
+		k1 = cyclicMod(k+1, m)
+		k2 := cyclicMod(k+2, m)
+		if convc[k1] != conv {
+			return outlineOptimization{}, false
+		}
+		if signFloat(cprod(vertex[i], vertex[i1], vertex[k1], vertex[k2])) != conv {
+			return outlineOptimization{}, false
+		}
+		if iprod1(vertex[i], vertex[i1], vertex[k1], vertex[k2]) < d*vertex[k1].distance(vertex[k2])*cos179 {
+			return outlineOptimization{}, false
+		}
+	}
+
+	p0 := curve.c[cyclicMod(i, m)][2]
+	p1 := vertex[cyclicMod(i+1, m)]
+	p2 := vertex[cyclicMod(j, m)]
+	p3 := curve.c[cyclicMod(j, m)][2]
+
+	area := areac[j] - areac[i]
+	area -= dpara(vertex[0], curve.c[i][2], curve.c[j][2]) / 2
+	if i >= j {
+		area += areac[m]
+	}
+
+	// Find the intersection o of p0p1 and p2p3 with o = interval(t, p0, p1) =
+	// interval(s, p3, p2). A is the area of the triangle (p0, o, p3).
+	a1 := dpara(p0, p1, p2)
+	a2 := dpara(p0, p1, p3)
+	a3 := dpara(p0, p2, p3)
+	a4 := a1 + a3 - a2
+	if a2 == a1 {
+		return outlineOptimization{}, false
+	}
+	t := a3 / (a3 - a4)
+	s := a2 / (a2 - a1)
+	a := a2 * t / 2
+	if a == 0 {
+		return outlineOptimization{}, false
+	}
+	r := area / a
+	alpha := 2 - math.Sqrt(4-r/.3)
+	res := outlineOptimization{
+		c:     [2]point{interval(t*alpha, p0, p1), interval(s*alpha, p3, p2)},
+		t:     t,
+		s:     s,
+		alpha: alpha,
+	}
+	bezier := cubicBezier{P0: p0, P1: res.c[0], P2: res.c[1], P3: p3}
+
+	// Check tangency with edges.
+	for k := cyclicMod(i+1, m); k != j; k = k1 {
+		k1 = cyclicMod(k+1, m)
+		t := bezierTangent(bezier, vertex[k], vertex[k1])
+		if t < -.5 {
+			return outlineOptimization{}, false
+		}
+		pt := bezier.at(t)
+		d := vertex[k].distance(vertex[k1])
+		if d == 0 {
+			return outlineOptimization{}, false
+		}
+		d1 := dpara(vertex[k], vertex[k1], pt) / d
+		if math.Abs(d1) > tolerance {
+			return outlineOptimization{}, false
+		}
+		if iprod(vertex[k], vertex[k1], pt) < 0 || iprod(vertex[k1], vertex[k], pt) < 0 {
+			return outlineOptimization{}, false
+		}
+		res.pen += d1 * d1
+	}
+
+	// Check corners.
+	for k := i; k != j; k = k1 {
+		k1 = cyclicMod(k+1, m)
+		t := bezierTangent(bezier, curve.c[k][2], curve.c[k1][2])
+		if t < -.5 {
+			return outlineOptimization{}, false
+		}
+		pt := bezier.at(t)
+		d := curve.c[k][2].distance(curve.c[k1][2])
+		if d == 0 {
+			return outlineOptimization{}, false
+		}
+		d1 := dpara(curve.c[k][2], curve.c[k1][2], pt) / d
+		d2 := dpara(curve.c[k][2], curve.c[k1][2], vertex[k1]) / d
+		d2 *= .75 * curve.alpha[k1]
+		if d2 < 0 {
+			d1 = -d1
+			d2 = -d2
+		}
+		if d1 < d2-tolerance {
+			return outlineOptimization{}, false
+		}
+		if d1 < d2 {
+			res.pen += (d1 - d2) * (d1 - d2)
+		}
+	}
+	return res, true
+}
+
+// bezierTangent returns the parameter t in [0, 1] at which the curve is
+// parallel to q0q1 or -1 if there is none.
+func bezierTangent(b cubicBezier, q0, q1 point) float64 {
+	// Solve (1-t)^2 A + 2(1-t)t B + t^2 C = 0.
+	A := cprod(b.P0, b.P1, q0, q1)
+	B := cprod(b.P1, b.P2, q0, q1)
+	C := cprod(b.P2, b.P3, q0, q1)
+	a := A - 2*B + C
+	bb := -2*A + 2*B
+	c := A
+	d := bb*bb - 4*a*c
+	if a == 0 || d < 0 {
+		return -1
+	}
+	s := math.Sqrt(d)
+	r1 := (-bb + s) / (2 * a)
+	r2 := (-bb - s) / (2 * a)
+	if r1 >= 0 && r1 <= 1 {
+		return r1
+	} else if r2 >= 0 && r2 <= 1 {
+		return r2
+	}
+	return -1
+}
+
+// optimize joins consecutive curves where the result deviates by at most the
+// given tolerance.
+func (curve *outlinePolygonCurve) optimize(tolerance float64) *outlinePolygonCurve {
+	m := len(curve.vertex)
+	pt := make([]int, m+1)
+	pen := make([]float64, m+1)
+	length := make([]int, m+1)
+	opt := make([]outlineOptimization, m+1)
+
+	// Pre-calculate convexity: 1 for right turns, -1 for left turns and 0 for
+	// corners.
+	convc := make([]int, m)
+	for i := 0; i < m; i++ {
+		if !curve.corner[i] {
+			convc[i] = signFloat(dpara(curve.vertex[cyclicMod(i-1, m)], curve.vertex[i], curve.vertex[cyclicMod(i+1, m)]))
+		}
+	}
+
+	// Pre-calculate areas.
+	areac := make([]float64, m+1)
+	area := 0.0
+	p0 := curve.vertex[0]
+	for i := 0; i < m; i++ {
+		i1 := cyclicMod(i+1, m)
+		if !curve.corner[i1] {
+			alpha := curve.alpha[i1]
+			area += .3 * alpha * (4 - alpha) * dpara(curve.c[i][2], curve.vertex[i1], curve.c[i1][2]) / 2
+			area += dpara(p0, curve.c[i][2], curve.c[i1][2]) / 2
+		}
+		areac[i+1] = area
+	}
+
+	pt[0] = -1
+	for j := 1; j <= m; j++ {
+		// Calculate the best path from 0 to j.
+		pt[j] = j - 1
+		pen[j] = pen[j-1]
+		length[j] = length[j-1] + 1
+		for i := j - 2; i >= 0; i-- {
+			o, ok := curve.optimizationPenalty(i, cyclicMod(j, m), tolerance, convc, areac)
+			if !ok {
+				break
+			}
+			if length[j] > length[i]+1 || (length[j] == length[i]+1 && pen[j] > pen[i]+o.pen) {
+				pt[j] = i
+				pen[j] = pen[i] + o.pen
+				length[j] = length[i] + 1
+				opt[j] = o
+			}
+		}
+	}
+
+	om := length[m]
+	optimized := newOutlinePolygonCurve(om)
+	s := make([]float64, om)
+	t := make([]float64, om)
+	j := m
+	for i := om - 1; i >= 0; i-- {
+		jm := cyclicMod(j, m)
+		if pt[j] == j-1 {
+			optimized.corner[i] = curve.corner[jm]
+			optimized.c[i] = curve.c[jm]
+			optimized.vertex[i] = curve.vertex[jm]
+			optimized.alpha[i] = curve.alpha[jm]
+			optimized.beta[i] = curve.beta[jm]
+			s[i], t[i] = 1, 1
+		} else {
+			optimized.corner[i] = false
+			optimized.c[i] = [3]point{opt[j].c[0], opt[j].c[1], curve.c[jm][2]}
+			optimized.vertex[i] = interval(opt[j].s, curve.c[jm][2], curve.vertex[jm])
+			optimized.alpha[i] = opt[j].alpha
+			s[i], t[i] = opt[j].s, opt[j].t
+		}
+		j = pt[j]
+	}
+	for i := 0; i < om; i++ {
+		optimized.beta[i] = s[i] / (s[i] + t[cyclicMod(i+1, om)])
+	}
+	return optimized
+}
+
+// segments returns the segments of the curve.
+func (curve *outlinePolygonCurve) segments() []outlineSegment {
+	segments := make([]outlineSegment, len(curve.c))
+	for i := range curve.c {
+		segments[i] = outlineSegment{
+			Corner: curve.corner[i],
+			C:      curve.c[i],
+		}
+	}
+	return segments
+}
+
+// outlineGeometry converts the given curves of traceOutlines to geometry with
+// the y-axis pointing down.
+func outlineGeometry(width, height int, curves []outlineCurve) tracedGeometry {
+	geometry := tracedGeometry{
+		Width:  width,
+		Height: height,
+		Paths:  make([]tracedPath, 0, len(curves)),
+	}
+	flip := func(p point) point {
+		return point{X: p.X, Y: float64(height) - p.Y}
+	}
+	for _, curve := range curves {
+		if len(curve.Segments) == 0 {
+			continue
+		}
+		path := tracedPath{
+			Curves: make([]cubicBezier, 0, len(curve.Segments)),
+			Closed: true,
+		}
+		current := flip(curve.Segments[len(curve.Segments)-1].C[2])
+		for _, segment := range curve.Segments {
+			if segment.Corner {
+				for _, p := range segment.C[1:] {
+					path.Curves = append(path.Curves, lineBezier(current, flip(p)))
+					current = flip(p)
+				}
+				continue
+			}
+			end := flip(segment.C[2])
+			path.Curves = append(path.Curves, cubicBezier{P0: current, P1: flip(segment.C[0]), P2: flip(segment.C[1]), P3: end})
+			current = end
+		}
+		geometry.Paths = append(geometry.Paths, path)
+	}
+	return geometry
+}
+
+// traceOutline traces the outlines of the ink in the given bitmap without
+// potrace.
+func traceOutline(logger *zap.Logger, config TraceConfig, bm *bitmap) tracedGeometry {
+	start := time.Now()
+	curves := traceOutlines(bm, outlineTraceOptions{
+		TurnPolicy:            config.TurnPolicy,
+		TurdSize:              config.TurdSize,
+		AlphaMax:              config.AlphaMax,
+		OptimizeCurves:        config.CurveOptimizationTolerance > 0,
+		OptimizationTolerance: config.CurveOptimizationTolerance,
+	})
+	logger.Debug("outline traced",
+		zap.Int("curve_count", len(curves)),
+		zap.Duration("took", time.Since(start)))
+	return outlineGeometry(bm.width, bm.height, curves)
+}
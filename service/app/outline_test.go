@@ -0,0 +1,130 @@
+package app
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func Test_traceOutlines(t *testing.T) {
+	options := outlineTraceOptions{
+		TurnPolicy:            "minority",
+		TurdSize:              2,
+		AlphaMax:              1,
+		OptimizeCurves:        true,
+		OptimizationTolerance: .2,
+	}
+
+	t.Run("square", func(t *testing.T) {
+		bm := newBitmap(20, 20)
+		for y := 5; y < 15; y++ {
+			for x := 5; x < 15; x++ {
+				bm.pix[y*bm.width+x] = true
+			}
+		}
+		curves := traceOutlines(bm, options)
+		require.Len(t, curves, 1)
+		require.Len(t, curves[0].Segments, 4)
+		for _, segment := range curves[0].Segments {
+			assert.True(t, segment.Corner)
+			// Corners are at the pixel corners.
+			assert.InDelta(t, 0, math.Mod(segment.C[1].X, 5), 1e-9)
+			assert.InDelta(t, 0, math.Mod(segment.C[1].Y, 5), 1e-9)
+		}
+	})
+
+	t.Run("disc", func(t *testing.T) {
+		bm := newBitmap(40, 40)
+		for y := 0; y < bm.height; y++ {
+			for x := 0; x < bm.width; x++ {
+				bm.pix[y*bm.width+x] = math.Hypot(float64(x)-19.5, float64(y)-19.5) <= 15
+			}
+		}
+		unoptimized := options
+		unoptimized.OptimizeCurves = false
+		curves := traceOutlines(bm, unoptimized)
+		require.Len(t, curves, 1)
+		for _, segment := range curves[0].Segments {
+			assert.False(t, segment.Corner)
+			assert.InDelta(t, 15.5, segment.C[2].distance(point{X: 20, Y: 20}), 1)
+		}
+		optimized := traceOutlines(bm, options)
+		require.Len(t, optimized, 1)
+		assert.Less(t, len(optimized[0].Segments), len(curves[0].Segments))
+	})
+}
+
+func Test_outlineGeometry(t *testing.T) {
+	curves := []outlineCurve{{
+		Segments: []outlineSegment{
+			{Corner: true, C: [3]point{{}, {X: 10, Y: 0}, {X: 10, Y: 5}}},
+			{C: [3]point{{X: 10, Y: 8}, {X: 8, Y: 10}, {X: 5, Y: 10}}},
+			{Corner: true, C: [3]point{{}, {X: 0, Y: 10}, {X: 0, Y: 0}}},
+		},
+	}}
+	geometry := outlineGeometry(20, 10, curves)
+
+	assert.Equal(t, 20, geometry.Width)
+	assert.Equal(t, 10, geometry.Height)
+	require.Len(t, geometry.Paths, 1)
+	path := geometry.Paths[0]
+	assert.True(t, path.Closed)
+	require.Len(t, path.Curves, 5)
+	// The y-axis is flipped to point down.
+	assert.Equal(t, lineBezier(point{X: 0, Y: 10}, point{X: 10, Y: 10}), path.Curves[0])
+	assert.Equal(t, lineBezier(point{X: 10, Y: 10}, point{X: 10, Y: 5}), path.Curves[1])
+	assert.Equal(t, cubicBezier{P0: point{X: 10, Y: 5}, P1: point{X: 10, Y: 2}, P2: point{X: 8, Y: 0}, P3: point{X: 5, Y: 0}}, path.Curves[2])
+	assert.Equal(t, path.Curves[0].P0, path.Curves[4].P3)
+}
+
+// Test_builtinTracer_matchesPotrace traces the test images with both backends
+// and expects the same geometry. It needs the potrace binary, which is set via
+// the POTRACE_FILENAME environment variable.
+func Test_builtinTracer_matchesPotrace(t *testing.T) {
+	potraceFilename := os.Getenv("POTRACE_FILENAME")
+	if potraceFilename == "" {
+		t.Skip("POTRACE_FILENAME not set")
+	}
+	config := defaultTraceConfig()
+	config.TurdSize = 2
+	// boundsTolerance is the maximum difference of the path bounds in pixels. The
+	// SVG output of potrace is rounded to tenths of pixels.
+	const boundsTolerance = .1
+
+	for _, name := range []string{"img-crop.png", "img.png", "img-bw.png", "pentagon-bw.png", "Black_triangle.svg.png", "img-crop.jpg"} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("..", "testing", name))
+			require.NoError(t, err)
+			defer func() { _ = f.Close() }()
+			img, _, err := decodeImage(f)
+			require.NoError(t, err)
+			bm := thresholdBitmap(compositeOverColor(img, color.RGBA{R: 255, G: 255, B: 255, A: 255}), config.BlackLevel, config.Invert)
+			// Tracers may modify the bitmap.
+			potraceBitmap := &bitmap{width: bm.width, height: bm.height, pix: slices.Clone(bm.pix)}
+
+			want, err := potraceTracer{filename: potraceFilename}.trace(context.Background(), zap.NewNop(), config, potraceBitmap)
+			require.NoError(t, err)
+			got, err := builtinTracer{}.trace(context.Background(), zap.NewNop(), config, bm)
+			require.NoError(t, err)
+
+			assert.Equal(t, want.Width, got.Width)
+			assert.Equal(t, want.Height, got.Height)
+			assert.Len(t, got.curves(), len(want.curves()))
+			wantBounds, ok := want.pathBounds()
+			require.True(t, ok)
+			gotBounds, ok := got.pathBounds()
+			require.True(t, ok)
+			assert.InDelta(t, wantBounds.MinX, gotBounds.MinX, boundsTolerance)
+			assert.InDelta(t, wantBounds.MinY, gotBounds.MinY, boundsTolerance)
+			assert.InDelta(t, wantBounds.MaxX, gotBounds.MaxX, boundsTolerance)
+			assert.InDelta(t, wantBounds.MaxY, gotBounds.MaxY, boundsTolerance)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"image"
+	"image/draw"
+	"runtime"
+	"sync"
+)
+
+// minParallelRows is the minimum number of rows per chunk in parallelRows, so
+// that small images are not split into chunks that are too small for being
+// worth the overhead.
+const minParallelRows = 32
+
+// parallelRows calls fn concurrently for disjoint row ranges [minY, maxY) that
+// cover the range from 0 to height. It returns when all calls have finished.
+func parallelRows(height int, fn func(minY, maxY int)) {
+	chunks := min(runtime.GOMAXPROCS(0), (height+minParallelRows-1)/minParallelRows)
+	if chunks <= 1 {
+		fn(0, height)
+		return
+	}
+	rowsPerChunk := (height + chunks - 1) / chunks
+	var wg sync.WaitGroup
+	for minY := 0; minY < height; minY += rowsPerChunk {
+		maxY := min(minY+rowsPerChunk, height)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(minY, maxY)
+		}()
+	}
+	wg.Wait()
+}
+
+// toRGBA returns the given image as *image.RGBA with bounds starting at the
+// origin. If it already is one, it is returned as is. Otherwise, it is
+// converted, which uses optimized paths for common image types.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
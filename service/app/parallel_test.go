@@ -0,0 +1,59 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+	"testing"
+)
+
+// benchmarkImage returns an opaque image with thick dark rings on a light
+// background, similar to the scanned drawings the service is used with.
+func benchmarkImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			d := math.Hypot(float64(x-width/2), float64(y-height/2))
+			c := color.NRGBA{R: 240, G: 235, B: 230, A: 255}
+			if math.Mod(d, 80) < 12 {
+				c = color.NRGBA{R: 20, G: 30, B: uint8(x % 256), A: 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func Test_parallelRows(t *testing.T) {
+	for _, height := range []int{0, 1, minParallelRows, 1000} {
+		var mutex sync.Mutex
+		covered := make([]int, height)
+		parallelRows(height, func(minY, maxY int) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			for y := minY; y < maxY; y++ {
+				covered[y]++
+			}
+		})
+		for y, n := range covered {
+			assert.Equal(t, 1, n, "row %d with height %d", y, height)
+		}
+	}
+}
+
+func Test_toRGBA(t *testing.T) {
+	t.Run("origin rgba", func(t *testing.T) {
+		img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+		assert.Same(t, img, toRGBA(img))
+	})
+
+	t.Run("offset bounds", func(t *testing.T) {
+		img := image.NewGray(image.Rect(0, 0, 4, 4))
+		img.SetGray(3, 2, color.Gray{Y: 200})
+		rgba := toRGBA(img.SubImage(image.Rect(2, 1, 4, 4)))
+		assert.Equal(t, image.Rect(0, 0, 2, 3), rgba.Bounds())
+		assert.Equal(t, color.RGBA{R: 200, G: 200, B: 200, A: 255}, rgba.RGBAAt(1, 1))
+	})
+}
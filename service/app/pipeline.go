@@ -0,0 +1,782 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/disintegration/gift"
+	"github.com/lefinal/meh"
+	"go.uber.org/zap"
+	"image"
+	"image/color"
+	"maps"
+	"math"
+	"slices"
+)
+
+// maxPreprocessPipelineSteps limits the number of steps in a preprocessing
+// pipeline.
+const maxPreprocessPipelineSteps = 64
+
+// preprocessState is passed through all steps of a preprocessing pipeline.
+type preprocessState struct {
+	Logger *zap.Logger
+	// Raw is the image file as uploaded.
+	Raw []byte
+	// Scale is the factor by which the image was resized so far.
+	Scale float64
+	// Bilevel is true if a step thresholded the image to black and white. Later
+	// steps like blurring may introduce gray again, which is why the final image
+	// is checked as well.
+	Bilevel bool
+	// TraceConfig is the config for tracing the result. Steps thresholding the
+	// image like the tracer use its black level.
+	TraceConfig TraceConfig
+	// BlackLevel is the black level that a step used for thresholding with the
+	// black level from TraceConfig. It is nil if no step did.
+	BlackLevel *float64
+	// Limits are the image limits that must hold after each step.
+	Limits imageLimits
+}
+
+// preprocessStep is a single step in a preprocessing pipeline.
+type preprocessStep interface {
+	apply(state *preprocessState, img image.Image) (image.Image, error)
+}
+
+// preprocessStepFunc implements preprocessStep for a function.
+type preprocessStepFunc func(state *preprocessState, img image.Image) (image.Image, error)
+
+func (f preprocessStepFunc) apply(state *preprocessState, img image.Image) (image.Image, error) {
+	return f(state, img)
+}
+
+// preprocessPipeline is an ordered list of named preprocessing steps.
+type preprocessPipeline []namedPreprocessStep
+
+// namedPreprocessStep is a preprocessStep along with its name in the
+// preprocessStepRegistry for logging.
+type namedPreprocessStep struct {
+	Name string
+	Step preprocessStep
+}
+
+// preprocessStepSpec describes a step in a preprocessing pipeline as JSON, e.g.
+// {"step":"blur","params":{"radius":2}}.
+type preprocessStepSpec struct {
+	Step   string          `json:"step"`
+	Params json.RawMessage `json:"params"`
+}
+
+// preprocessStepFactory creates a preprocessing step from its JSON parameters.
+// The parameters are nil if none were given.
+type preprocessStepFactory func(params json.RawMessage) (preprocessStep, error)
+
+// preprocessStepRegistry holds all available preprocessing steps by name.
+var preprocessStepRegistry = map[string]preprocessStepFactory{
+	"exif_orientation":   newEXIFOrientationStepFromParams,
+	"crop":               newCropStepFromParams,
+	"alpha":              newAlphaStepFromParams,
+	"chroma_key":         newChromaKeyStepFromParams,
+	"flood_fill":         newFloodFillStepFromParams,
+	"adjust":             newAdjustStepFromParams,
+	"grayscale":          newGrayscaleStepFromParams,
+	"rotate":             newRotateStepFromParams,
+	"flip":               newFlipStepFromParams,
+	"auto_trim":          newAutoTrimStepFromParams,
+	"resize":             newResizeStepFromParams,
+	"median":             newMedianStepFromParams,
+	"blur":               newBlurStepFromParams,
+	"edge_detection":     newEdgeDetectionStepFromParams,
+	"adaptive_threshold": newAdaptiveThresholdStepFromParams,
+	"morphology":         newMorphologyStepFromParams,
+	"despeckle":          newDespeckleStepFromParams,
+}
+
+// registeredPreprocessSteps returns the sorted names of all steps in the
+// preprocessStepRegistry.
+func registeredPreprocessSteps() []string {
+	return slices.Sorted(maps.Keys(preprocessStepRegistry))
+}
+
+// parsePreprocessPipeline parses a JSON list of preprocessStepSpec.
+func parsePreprocessPipeline(raw string) (preprocessPipeline, error) {
+	var specs []preprocessStepSpec
+	decoder := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&specs)
+	if err != nil {
+		return nil, meh.NewBadInputErrFromErr(err, "decode pipeline", nil)
+	}
+	if len(specs) > maxPreprocessPipelineSteps {
+		return nil, meh.NewBadInputErr("too many pipeline steps", meh.Details{
+			"was": len(specs),
+			"max": maxPreprocessPipelineSteps,
+		})
+	}
+	pipeline := make(preprocessPipeline, 0, len(specs))
+	for i, spec := range specs {
+		factory, ok := preprocessStepRegistry[spec.Step]
+		if !ok {
+			return nil, meh.NewBadInputErr(fmt.Sprintf("unsupported pipeline step: %s", spec.Step),
+				meh.Details{"step_index": i, "allowed": registeredPreprocessSteps()})
+		}
+		step, err := factory(spec.Params)
+		if err != nil {
+			return nil, meh.Wrap(err, "create pipeline step", meh.Details{"step_index": i, "step": spec.Step})
+		}
+		pipeline = append(pipeline, namedPreprocessStep{Name: spec.Step, Step: step})
+	}
+	return pipeline, nil
+}
+
+// decodeStepParams decodes the JSON parameters of a step into v, which should
+// be prefilled with defaults.
+func decodeStepParams(params json.RawMessage, v any) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(params))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err != nil {
+		return meh.NewBadInputErrFromErr(err, "decode step params", nil)
+	}
+	return nil
+}
+
+// parseStepColor parses an optional hex color parameter. The fallback is used
+// if the parameter is empty. Colors are always made opaque.
+func parseStepColor(name string, v string, fallback color.RGBA) (color.RGBA, error) {
+	if v == "" {
+		return fallback, nil
+	}
+	c, err := parseHexRGBA(v)
+	if err != nil {
+		return color.RGBA{}, meh.NewBadInputErrFromErr(err, fmt.Sprintf("parse %s", name), meh.Details{"was": v})
+	}
+	c.A = 255
+	return c, nil
+}
+
+// defaultReplacementColor is the default color for transparent and removed
+// pixels.
+var defaultReplacementColor = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+
+func newEXIFOrientationStep() preprocessStep {
+	return preprocessStepFunc(func(state *preprocessState, img image.Image) (image.Image, error) {
+		// Phone cameras store pixels in sensor orientation.
+		orientation := readEXIFOrientation(state.Raw)
+		state.Logger.Debug("read exif orientation", zap.Int("orientation", int(orientation)))
+		return applyEXIFOrientation(img, orientation), nil
+	})
+}
+
+func newEXIFOrientationStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	err := decodeStepParams(params, &struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	return newEXIFOrientationStep(), nil
+}
+
+func newCropStep(rect cropRect) preprocessStep {
+	return preprocessStepFunc(func(_ *preprocessState, img image.Image) (image.Image, error) {
+		return cropImage(img, rect)
+	})
+}
+
+func newCropStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		Rect string `json:"rect"`
+	}{}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	rect, err := parseCropRect(p.Rect)
+	if err != nil {
+		return nil, meh.Wrap(err, "parse crop rect", nil)
+	}
+	return newCropStep(rect), nil
+}
+
+func newAlphaStep(mode string, replacement color.RGBA) preprocessStep {
+	return preprocessStepFunc(func(_ *preprocessState, img image.Image) (image.Image, error) {
+		switch mode {
+		case alphaModeMask:
+			return alphaMask(img), nil
+		default:
+			return compositeOverColor(img, replacement), nil
+		}
+	})
+}
+
+func newAlphaStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		Mode             string `json:"mode"`
+		ReplacementColor string `json:"replacement_color"`
+	}{
+		Mode: alphaModeComposite,
+	}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(allowedAlphaModes, p.Mode) {
+		return nil, meh.NewBadInputErr(fmt.Sprintf("unsupported alpha mode: %s", p.Mode),
+			meh.Details{"allowed": allowedAlphaModes})
+	}
+	replacement, err := parseStepColor("replacement color", p.ReplacementColor, defaultReplacementColor)
+	if err != nil {
+		return nil, err
+	}
+	return newAlphaStep(p.Mode, replacement), nil
+}
+
+// newBackgroundRemovalStep creates a step that removes the background in place
+// if the image is already an *image.RGBA. Otherwise, it is composited over the
+// replacement color first.
+func newBackgroundRemovalStep(options backgroundRemovalOptions, replacement color.RGBA) preprocessStep {
+	return preprocessStepFunc(func(_ *preprocessState, img image.Image) (image.Image, error) {
+		rgba, ok := img.(*image.RGBA)
+		if !ok {
+			rgba = compositeOverColor(img, replacement)
+		}
+		removeBackground(rgba, options, replacement)
+		return rgba, nil
+	})
+}
+
+func newChromaKeyStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		Color            string  `json:"color"`
+		Tolerance        float64 `json:"tolerance"`
+		ReplacementColor string  `json:"replacement_color"`
+	}{
+		Tolerance: 0.1,
+	}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Color == "" {
+		return nil, meh.NewBadInputErr("missing chroma key color", nil)
+	}
+	keyColor, err := parseStepColor("chroma key color", p.Color, color.RGBA{})
+	if err != nil {
+		return nil, err
+	}
+	replacement, err := parseStepColor("replacement color", p.ReplacementColor, defaultReplacementColor)
+	if err != nil {
+		return nil, err
+	}
+	p.Tolerance = min(p.Tolerance, 1)
+	p.Tolerance = max(p.Tolerance, 0)
+	return newBackgroundRemovalStep(backgroundRemovalOptions{
+		ChromaKeyColor:     &keyColor,
+		ChromaKeyTolerance: p.Tolerance,
+		FloodFill:          false,
+		FloodFillTolerance: 0,
+	}, replacement), nil
+}
+
+func newFloodFillStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		Tolerance        float64 `json:"tolerance"`
+		ReplacementColor string  `json:"replacement_color"`
+	}{
+		Tolerance: 0.1,
+	}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	replacement, err := parseStepColor("replacement color", p.ReplacementColor, defaultReplacementColor)
+	if err != nil {
+		return nil, err
+	}
+	p.Tolerance = min(p.Tolerance, 1)
+	p.Tolerance = max(p.Tolerance, 0)
+	return newBackgroundRemovalStep(backgroundRemovalOptions{
+		ChromaKeyColor:     nil,
+		ChromaKeyTolerance: 0,
+		FloodFill:          true,
+		FloodFillTolerance: p.Tolerance,
+	}, replacement), nil
+}
+
+func newAdjustStep(options adjustOptions) preprocessStep {
+	return preprocessStepFunc(func(_ *preprocessState, img image.Image) (image.Image, error) {
+		return adjustImage(img, options), nil
+	})
+}
+
+func newAdjustStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		LevelsBlack float64 `json:"levels_black"`
+		LevelsWhite float64 `json:"levels_white"`
+		Gamma       float64 `json:"gamma"`
+		Brightness  float64 `json:"brightness"`
+		Contrast    float64 `json:"contrast"`
+	}{
+		LevelsBlack: 0,
+		LevelsWhite: 1,
+		Gamma:       1,
+		Brightness:  0,
+		Contrast:    0,
+	}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	options := adjustOptions{
+		LevelsBlack: max(min(p.LevelsBlack, 1), 0),
+		LevelsWhite: max(min(p.LevelsWhite, 1), 0),
+		Gamma:       max(min(p.Gamma, 10), 0.01),
+		Brightness:  max(min(p.Brightness, 100), -100),
+		Contrast:    max(min(p.Contrast, 100), -100),
+	}
+	if options.LevelsBlack >= options.LevelsWhite {
+		return nil, meh.NewBadInputErr("levels black must be less than levels white", meh.Details{
+			"levels_black": options.LevelsBlack,
+			"levels_white": options.LevelsWhite,
+		})
+	}
+	return newAdjustStep(options), nil
+}
+
+func newGrayscaleStep(options grayscaleOptions) preprocessStep {
+	return preprocessStepFunc(func(_ *preprocessState, img image.Image) (image.Image, error) {
+		return convertGrayscale(img, options), nil
+	})
+}
+
+func newGrayscaleStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		Method           string  `json:"method"`
+		HueMin           float64 `json:"hue_min"`
+		HueMax           float64 `json:"hue_max"`
+		HueMinSaturation float64 `json:"hue_min_saturation"`
+	}{
+		Method:           defaultGrayscaleMethod,
+		HueMin:           0,
+		HueMax:           0,
+		HueMinSaturation: 0.2,
+	}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(allowedGrayscaleMethods, p.Method) {
+		return nil, meh.NewBadInputErr(fmt.Sprintf("unsupported grayscale method: %s", p.Method),
+			meh.Details{"allowed": allowedGrayscaleMethods})
+	}
+	return newGrayscaleStep(grayscaleOptions{
+		Method:           p.Method,
+		HueMin:           max(min(p.HueMin, 360), 0),
+		HueMax:           max(min(p.HueMax, 360), 0),
+		HueMinSaturation: max(min(p.HueMinSaturation, 1), 0),
+	}), nil
+}
+
+func newOrientationStep(options transformOrientationOptions) preprocessStep {
+	return preprocessStepFunc(func(state *preprocessState, img image.Image) (image.Image, error) {
+		img, err := transformOrientation(img, options, state.Limits)
+		if err != nil {
+			return nil, meh.Wrap(err, "transform orientation", nil)
+		}
+		return img, nil
+	})
+}
+
+func newRotateStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		Degrees   float64 `json:"degrees"`
+		FillColor string  `json:"fill_color"`
+	}{}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	fillColor, err := parseStepColor("fill color", p.FillColor, defaultReplacementColor)
+	if err != nil {
+		return nil, err
+	}
+	return newOrientationStep(transformOrientationOptions{
+		RotateDegrees:   p.Degrees,
+		RotateFillColor: fillColor,
+		FlipHorizontal:  false,
+		FlipVertical:    false,
+	}), nil
+}
+
+func newFlipStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		Horizontal bool `json:"horizontal"`
+		Vertical   bool `json:"vertical"`
+	}{}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	return newOrientationStep(transformOrientationOptions{
+		RotateDegrees:   0,
+		RotateFillColor: defaultReplacementColor,
+		FlipHorizontal:  p.Horizontal,
+		FlipVertical:    p.Vertical,
+	}), nil
+}
+
+func newAutoTrimStep(options autoTrimOptions) preprocessStep {
+	return preprocessStepFunc(func(state *preprocessState, img image.Image) (image.Image, error) {
+		img = autoTrim(img, options)
+		state.Logger.Debug("trimmed image", zap.Stringer("size", img.Bounds().Size()))
+		return img, nil
+	})
+}
+
+func newAutoTrimStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		Tolerance float64 `json:"tolerance"`
+		Padding   int     `json:"padding"`
+	}{
+		Tolerance: 0.1,
+		Padding:   0,
+	}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	return newAutoTrimStep(autoTrimOptions{
+		Enabled:   true,
+		Tolerance: max(min(p.Tolerance, 1), 0),
+		Padding:   max(min(p.Padding, maxAutoTrimPadding), 0),
+	}), nil
+}
+
+func newResizeStep(options resizeOptions) preprocessStep {
+	return preprocessStepFunc(func(state *preprocessState, img image.Image) (image.Image, error) {
+		img, scale, err := resizeImage(img, options.withinLimits(state.Limits))
+		if err != nil {
+			return nil, meh.Wrap(err, "resize image", nil)
+		}
+		state.Scale *= scale
+		state.Logger.Debug("resized image", zap.Float64("scale", scale), zap.Stringer("size", img.Bounds().Size()))
+		return img, nil
+	})
+}
+
+func newResizeStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		MaxLongEdge int    `json:"max_long_edge"`
+		MinLongEdge int    `json:"min_long_edge"`
+		MaxPixels   int    `json:"max_pixels"`
+		Resample    string `json:"resample"`
+	}{
+		Resample: "lanczos",
+	}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	options := resizeOptions{
+		MaxLongEdge: max(min(p.MaxLongEdge, maxResizeLongEdge), 0),
+		MinLongEdge: max(min(p.MinLongEdge, maxResizeLongEdge), 0),
+		MaxPixels:   max(p.MaxPixels, 0),
+		Resample:    p.Resample,
+	}
+	if options.MaxLongEdge > 0 && options.MinLongEdge > options.MaxLongEdge {
+		return nil, meh.NewBadInputErr("min long edge must not exceed max long edge", meh.Details{
+			"min_long_edge": options.MinLongEdge,
+			"max_long_edge": options.MaxLongEdge,
+		})
+	}
+	_, err = resampleFilterByName(options.Resample)
+	if err != nil {
+		return nil, meh.Wrap(err, "resample filter by name", nil)
+	}
+	return newResizeStep(options), nil
+}
+
+func newMedianStep(size int) preprocessStep {
+	return preprocessStepFunc(func(_ *preprocessState, img image.Image) (image.Image, error) {
+		filteredImage := image.NewRGBA(img.Bounds())
+		g := gift.New(gift.Median(size, true))
+		g.Draw(filteredImage, img)
+		return filteredImage, nil
+	})
+}
+
+func newMedianStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		Size int `json:"size"`
+	}{
+		Size: 3,
+	}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	p.Size = min(p.Size, maxMedianSize)
+	p.Size = max(p.Size, 1)
+	// Kernel must be centered around the pixel.
+	if p.Size%2 == 0 {
+		p.Size++
+	}
+	return newMedianStep(p.Size), nil
+}
+
+func newBlurStep(radius float32) preprocessStep {
+	return preprocessStepFunc(func(_ *preprocessState, img image.Image) (image.Image, error) {
+		blurredImage := image.NewRGBA(img.Bounds())
+		g := gift.New(gift.GaussianBlur(radius))
+		g.Draw(blurredImage, img)
+		return blurredImage, nil
+	})
+}
+
+func newBlurStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		Radius float64 `json:"radius"`
+	}{
+		Radius: 1,
+	}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	p.Radius = min(p.Radius, math.MaxFloat32)
+	p.Radius = max(p.Radius, 0)
+	return newBlurStep(float32(p.Radius)), nil
+}
+
+func newEdgeDetectionStep(options edgeDetectionOptions) preprocessStep {
+	return preprocessStepFunc(func(state *preprocessState, img image.Image) (image.Image, error) {
+		state.Bilevel = true
+		return detectEdges(img, options), nil
+	})
+}
+
+func newEdgeDetectionStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		Method        string  `json:"method"`
+		Sigma         float64 `json:"sigma"`
+		LowThreshold  float64 `json:"low_threshold"`
+		HighThreshold float64 `json:"high_threshold"`
+		Thickness     int     `json:"thickness"`
+	}{
+		Method:        edgeDetectionCanny,
+		Sigma:         1.4,
+		LowThreshold:  0.1,
+		HighThreshold: 0.2,
+		Thickness:     0,
+	}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Method == edgeDetectionNone || !slices.Contains(allowedEdgeDetectionMethods, p.Method) {
+		return nil, meh.NewBadInputErr(fmt.Sprintf("unsupported edge detection method: %s", p.Method),
+			meh.Details{"allowed": allowedEdgeDetectionMethods})
+	}
+	options := edgeDetectionOptions{
+		Method:        p.Method,
+		Sigma:         max(min(p.Sigma, 20), 0),
+		LowThreshold:  max(min(p.LowThreshold, 1), 0),
+		HighThreshold: max(min(p.HighThreshold, 1), 0),
+		Thickness:     max(min(p.Thickness, maxEdgeThickness), 0),
+	}
+	if options.LowThreshold > options.HighThreshold {
+		return nil, meh.NewBadInputErr("edge low threshold must not exceed high threshold", meh.Details{
+			"low_threshold":  options.LowThreshold,
+			"high_threshold": options.HighThreshold,
+		})
+	}
+	return newEdgeDetectionStep(options), nil
+}
+
+func newAdaptiveThresholdStep(options adaptiveThresholdOptions) preprocessStep {
+	return preprocessStepFunc(func(state *preprocessState, img image.Image) (image.Image, error) {
+		state.Bilevel = true
+		return adaptiveThreshold(img, options), nil
+	})
+}
+
+func newAdaptiveThresholdStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		Method     string  `json:"method"`
+		WindowSize int     `json:"window_size"`
+		Offset     float64 `json:"offset"`
+	}{
+		Method:     adaptiveThresholdMethodMean,
+		WindowSize: 31,
+		Offset:     10,
+	}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Method == adaptiveThresholdMethodNone || !slices.Contains(allowedAdaptiveThresholdMethods, p.Method) {
+		return nil, meh.NewBadInputErr(fmt.Sprintf("unsupported adaptive threshold method: %s", p.Method),
+			meh.Details{"allowed": allowedAdaptiveThresholdMethods})
+	}
+	p.WindowSize = min(p.WindowSize, maxAdaptiveThresholdWindowSize)
+	p.WindowSize = max(p.WindowSize, 3)
+	// Window must be centered around the pixel.
+	if p.WindowSize%2 == 0 {
+		p.WindowSize++
+	}
+	return newAdaptiveThresholdStep(adaptiveThresholdOptions{
+		Method:     p.Method,
+		WindowSize: p.WindowSize,
+		Offset:     max(min(p.Offset, 255), -255),
+	}), nil
+}
+
+func newMorphologyStep(options morphologyOptions) preprocessStep {
+	return preprocessStepFunc(func(state *preprocessState, img image.Image) (image.Image, error) {
+		// Threshold like the tracer would, so that the black level of the request
+		// is respected.
+		blackLevel := state.TraceConfig.blackLevelFor(state.Logger, img)
+		state.Bilevel = true
+		state.BlackLevel = &blackLevel
+		return applyMorphology(img, options, blackLevel), nil
+	})
+}
+
+func newMorphologyStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		Ops    string `json:"ops"`
+		Kernel string `json:"kernel"`
+		Radius int    `json:"radius"`
+	}{
+		Kernel: morphologyKernelDisk,
+		Radius: 1,
+	}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(allowedMorphologyKernels, p.Kernel) {
+		return nil, meh.NewBadInputErr(fmt.Sprintf("unsupported morphology kernel: %s", p.Kernel),
+			meh.Details{"allowed": allowedMorphologyKernels})
+	}
+	steps, err := parseMorphologySteps(p.Ops, p.Radius)
+	if err != nil {
+		return nil, meh.Wrap(err, "parse morphology steps", nil)
+	}
+	return newMorphologyStep(morphologyOptions{
+		Steps:  steps,
+		Kernel: p.Kernel,
+	}), nil
+}
+
+func newDespeckleStep(size int) preprocessStep {
+	return preprocessStepFunc(func(state *preprocessState, img image.Image) (image.Image, error) {
+		// Like the turd size, the area refers to the original resolution.
+		minArea := int(math.Round(float64(size) * state.Scale * state.Scale))
+		return despeckle(img, minArea), nil
+	})
+}
+
+func newDespeckleStepFromParams(params json.RawMessage) (preprocessStep, error) {
+	p := struct {
+		Size int `json:"size"`
+	}{
+		Size: 10,
+	}
+	err := decodeStepParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	p.Size = min(p.Size, 100_000_000)
+	p.Size = max(p.Size, 0)
+	return newDespeckleStep(p.Size), nil
+}
+
+// pipeline returns the preprocessing pipeline that is equivalent to the
+// options. Only steps that the options enable are included.
+func (options preprocessImageOptions) pipeline() preprocessPipeline {
+	pipeline := make(preprocessPipeline, 0)
+	add := func(name string, step preprocessStep) {
+		pipeline = append(pipeline, namedPreprocessStep{Name: name, Step: step})
+	}
+	if options.ApplyEXIFOrientation {
+		add("exif_orientation", newEXIFOrientationStep())
+	}
+	if options.Crop != nil {
+		add("crop", newCropStep(*options.Crop))
+	}
+	beforeAlpha := len(pipeline)
+	if options.BackgroundRemoval.ChromaKeyColor != nil {
+		add("chroma_key", newBackgroundRemovalStep(backgroundRemovalOptions{
+			ChromaKeyColor:     options.BackgroundRemoval.ChromaKeyColor,
+			ChromaKeyTolerance: options.BackgroundRemoval.ChromaKeyTolerance,
+			FloodFill:          false,
+			FloodFillTolerance: 0,
+		}, options.TransparencyReplacementColor))
+	}
+	if options.BackgroundRemoval.FloodFill {
+		add("flood_fill", newBackgroundRemovalStep(backgroundRemovalOptions{
+			ChromaKeyColor:     nil,
+			ChromaKeyTolerance: 0,
+			FloodFill:          true,
+			FloodFillTolerance: options.BackgroundRemoval.FloodFillTolerance,
+		}, options.TransparencyReplacementColor))
+	}
+	if !options.Adjust.isNeutral() {
+		add("adjust", newAdjustStep(options.Adjust))
+	}
+	if options.Grayscale.Method != grayscaleMethodAverage {
+		add("grayscale", newGrayscaleStep(options.Grayscale))
+	}
+	if options.Orientation.RotateDegrees != 0 {
+		add("rotate", newOrientationStep(transformOrientationOptions{
+			RotateDegrees:   options.Orientation.RotateDegrees,
+			RotateFillColor: options.Orientation.RotateFillColor,
+			FlipHorizontal:  false,
+			FlipVertical:    false,
+		}))
+	}
+	if options.Orientation.FlipHorizontal || options.Orientation.FlipVertical {
+		add("flip", newOrientationStep(transformOrientationOptions{
+			RotateDegrees:   0,
+			RotateFillColor: options.Orientation.RotateFillColor,
+			FlipHorizontal:  options.Orientation.FlipHorizontal,
+			FlipVertical:    options.Orientation.FlipVertical,
+		}))
+	}
+	if options.AutoTrim.Enabled {
+		add("auto_trim", newAutoTrimStep(options.AutoTrim))
+	}
+	if !options.Resize.isNeutral() {
+		add("resize", newResizeStep(options.Resize))
+	}
+	if options.MedianSize > 1 {
+		add("median", newMedianStep(options.MedianSize))
+	}
+	if options.BlurRadius > 0 {
+		add("blur", newBlurStep(options.BlurRadius))
+	}
+	if options.EdgeDetection.Method != edgeDetectionNone {
+		add("edge_detection", newEdgeDetectionStep(options.EdgeDetection))
+	}
+	if options.AdaptiveThreshold.Method != adaptiveThresholdMethodNone {
+		add("adaptive_threshold", newAdaptiveThresholdStep(options.AdaptiveThreshold))
+	}
+	if len(options.Morphology.Steps) > 0 {
+		add("morphology", newMorphologyStep(options.Morphology))
+	}
+	if options.DespeckleSize > 0 {
+		add("despeckle", newDespeckleStep(options.DespeckleSize))
+	}
+	// The following steps expect opaque pixels. Without any, compositing over the
+	// default replacement color is left to preprocessImage.
+	if len(pipeline) > beforeAlpha || options.AlphaMode != alphaModeComposite ||
+		options.TransparencyReplacementColor != defaultReplacementColor {
+		alpha := namedPreprocessStep{Name: "alpha", Step: newAlphaStep(options.AlphaMode, options.TransparencyReplacementColor)}
+		pipeline = slices.Insert(pipeline, beforeAlpha, alpha)
+	}
+	return pipeline
+}
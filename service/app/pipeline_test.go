@@ -0,0 +1,283 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/lefinal/meh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func Test_parsePreprocessPipeline(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		wantSteps []string
+		wantErr   bool
+	}{
+		{
+			name:      "empty",
+			raw:       `[]`,
+			wantSteps: []string{},
+		},
+		{
+			name:      "ordered steps",
+			raw:       `[{"step":"blur","params":{"radius":2}},{"step":"adaptive_threshold","params":{"method":"gaussian"}},{"step":"morphology","params":{"ops":"dilate:2"}}]`,
+			wantSteps: []string{"blur", "adaptive_threshold", "morphology"},
+		},
+		{
+			name:      "without params",
+			raw:       `[{"step":"grayscale"},{"step":"despeckle","params":null}]`,
+			wantSteps: []string{"grayscale", "despeckle"},
+		},
+		{
+			name:    "unknown step",
+			raw:     `[{"step":"sharpen"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown param",
+			raw:     `[{"step":"blur","params":{"radus":2}}]`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid param",
+			raw:     `[{"step":"grayscale","params":{"method":"purple"}}]`,
+			wantErr: true,
+		},
+		{
+			name:    "missing required param",
+			raw:     `[{"step":"crop"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "no list",
+			raw:     `{"step":"blur"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePreprocessPipeline(tt.raw)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			names := make([]string, 0)
+			for _, step := range got {
+				names = append(names, step.Name)
+			}
+			assert.Equal(t, tt.wantSteps, names)
+		})
+	}
+}
+
+func Test_preprocessStepRegistry(t *testing.T) {
+	// Steps with required params.
+	required := map[string]string{
+		"crop":       `{"rect":"1,1,2,2"}`,
+		"chroma_key": `{"color":"00FF00FF"}`,
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	img.SetRGBA(1, 1, color.RGBA{A: 255})
+	for name, factory := range preprocessStepRegistry {
+		t.Run(name, func(t *testing.T) {
+			step, err := factory(json.RawMessage(required[name]))
+			require.NoError(t, err)
+			state := &preprocessState{Logger: zap.NewNop(), Scale: 1}
+			got, err := step.apply(state, img)
+			require.NoError(t, err)
+			assert.False(t, got.Bounds().Empty())
+		})
+	}
+}
+
+func Test_preprocessImageOptionsPipeline(t *testing.T) {
+	options := preprocessImageOptions{
+		ApplyEXIFOrientation:         true,
+		AlphaMode:                    alphaModeComposite,
+		TransparencyReplacementColor: defaultReplacementColor,
+		Adjust:                       adjustOptions{LevelsBlack: 0, LevelsWhite: 1, Gamma: 1},
+		Grayscale:                    grayscaleOptions{Method: grayscaleMethodAverage},
+		Resize:                       resizeOptions{Resample: "lanczos"},
+		EdgeDetection:                edgeDetectionOptions{Method: edgeDetectionNone},
+		AdaptiveThreshold:            adaptiveThresholdOptions{Method: adaptiveThresholdMethodNone},
+	}
+	names := func(pipeline preprocessPipeline) []string {
+		n := make([]string, 0)
+		for _, step := range pipeline {
+			n = append(n, step.Name)
+		}
+		return n
+	}
+	assert.Equal(t, []string{"exif_orientation"}, names(options.pipeline()))
+
+	alphaMask := options
+	alphaMask.AlphaMode = alphaModeMask
+	assert.Equal(t, []string{"exif_orientation", "alpha"}, names(alphaMask.pipeline()))
+
+	options.Resize.MaxPixels = 1000
+	assert.Equal(t, []string{"exif_orientation", "alpha", "resize"}, names(options.pipeline()))
+
+	options.Resize.MaxPixels = 0
+	options.BlurRadius = 2
+	options.DespeckleSize = 10
+	options.Orientation.FlipVertical = true
+	assert.Equal(t, []string{"exif_orientation", "alpha", "flip", "blur", "despeckle"}, names(options.pipeline()))
+
+	options.BackgroundRemoval = backgroundRemovalOptions{ChromaKeyColor: &color.RGBA{G: 255, A: 255}, FloodFill: true}
+	options.Orientation.RotateDegrees = 30
+	options.Grayscale.Method = grayscaleMethodLuminance
+	options.AdaptiveThreshold.Method = adaptiveThresholdMethodMean
+	got := names(options.pipeline())
+	assert.Equal(t, []string{"exif_orientation", "alpha", "chroma_key", "flood_fill", "grayscale", "rotate", "flip",
+		"blur", "adaptive_threshold", "despeckle"}, got)
+	for _, name := range got {
+		assert.Contains(t, preprocessStepRegistry, name, "step names must match the registry")
+	}
+}
+
+func Test_preprocessPipelineFromQueryParams(t *testing.T) {
+	// contextWithQuery returns a gin.Context for a request with the given query.
+	contextWithQuery := func(query url.Values) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/?"+query.Encode(), nil)
+		return c
+	}
+
+	t.Run("pipeline", func(t *testing.T) {
+		got, err := preprocessPipelineFromQueryParams(contextWithQuery(url.Values{
+			"preprocess_pipeline": {`[{"step":"blur"}]`},
+			"trace_turd_size":     {"2"},
+		}))
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assert.Equal(t, "blur", got[0].Name)
+	})
+
+	t.Run("legacy params", func(t *testing.T) {
+		got, err := preprocessPipelineFromQueryParams(contextWithQuery(url.Values{
+			"preprocess_blur_radius": {"2"},
+		}))
+		require.NoError(t, err)
+		assert.NotEmpty(t, got)
+	})
+
+	t.Run("pipeline with legacy params", func(t *testing.T) {
+		_, err := preprocessPipelineFromQueryParams(contextWithQuery(url.Values{
+			"preprocess_pipeline":    {`[{"step":"blur"}]`},
+			"preprocess_blur_radius": {"2"},
+		}))
+		require.Error(t, err)
+		assert.Equal(t, meh.ErrBadInput, meh.ErrorCode(err))
+	})
+}
+
+func Test_App_preprocessImage(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 100, 100))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	draw.Draw(img, image.Rect(25, 25, 75, 75), image.Black, image.Point{}, draw.Src)
+	var raw bytes.Buffer
+	require.NoError(t, png.Encode(&raw, img))
+	app := &App{config: Config{MaxImageWidth: 1000, MaxImageHeight: 1000, MaxImagePixels: 40_000}}
+
+	t.Run("within limits", func(t *testing.T) {
+		pipeline, err := parsePreprocessPipeline(`[{"step":"rotate","params":{"degrees":45}},{"step":"resize","params":{"min_long_edge":16384}}]`)
+		require.NoError(t, err)
+		got, err := app.preprocessImage(zap.NewNop(), bytes.NewReader(raw.Bytes()), pipeline, defaultTraceConfig())
+		require.NoError(t, err)
+		size := got.Image.Bounds().Size()
+		assert.LessOrEqual(t, size.X*size.Y, 40_000)
+	})
+
+	t.Run("bilevel after threshold", func(t *testing.T) {
+		pipeline, err := parsePreprocessPipeline(`[{"step":"adaptive_threshold"}]`)
+		require.NoError(t, err)
+		got, err := app.preprocessImage(zap.NewNop(), bytes.NewReader(raw.Bytes()), pipeline, defaultTraceConfig())
+		require.NoError(t, err)
+		assert.True(t, got.Bilevel)
+	})
+
+	t.Run("not bilevel after blurring threshold", func(t *testing.T) {
+		pipeline, err := parsePreprocessPipeline(`[{"step":"adaptive_threshold"},{"step":"blur","params":{"radius":3}}]`)
+		require.NoError(t, err)
+		got, err := app.preprocessImage(zap.NewNop(), bytes.NewReader(raw.Bytes()), pipeline, defaultTraceConfig())
+		require.NoError(t, err)
+		assert.False(t, got.Bilevel)
+	})
+
+	t.Run("morphology uses trace black level", func(t *testing.T) {
+		pipeline, err := parsePreprocessPipeline(`[{"step":"morphology","params":{"ops":"dilate"}}]`)
+		require.NoError(t, err)
+		traceConfig := defaultTraceConfig()
+		traceConfig.BlackLevel = .7
+		got, err := app.preprocessImage(zap.NewNop(), bytes.NewReader(raw.Bytes()), pipeline, traceConfig)
+		require.NoError(t, err)
+		assert.True(t, got.Bilevel)
+		require.NotNil(t, got.BlackLevel)
+		assert.Equal(t, .7, *got.BlackLevel)
+	})
+
+	t.Run("morphology uses automatic black level", func(t *testing.T) {
+		pipeline, err := parsePreprocessPipeline(`[{"step":"morphology","params":{"ops":"dilate"}}]`)
+		require.NoError(t, err)
+		traceConfig := defaultTraceConfig()
+		traceConfig.AutoBlackLevel = true
+		got, err := app.preprocessImage(zap.NewNop(), bytes.NewReader(raw.Bytes()), pipeline, traceConfig)
+		require.NoError(t, err)
+		require.NotNil(t, got.BlackLevel)
+		assert.Equal(t, otsuBlackLevel(img), *got.BlackLevel)
+	})
+
+	t.Run("repeated rotation exceeds limits", func(t *testing.T) {
+		pipeline, err := parsePreprocessPipeline(`[{"step":"rotate","params":{"degrees":45}},{"step":"rotate","params":{"degrees":45}},{"step":"rotate","params":{"degrees":45}}]`)
+		require.NoError(t, err)
+		_, err = app.preprocessImage(zap.NewNop(), bytes.NewReader(raw.Bytes()), pipeline, defaultTraceConfig())
+		require.Error(t, err)
+		assert.Equal(t, meh.ErrBadInput, meh.ErrorCode(err))
+	})
+
+	t.Run("padding exceeds limits", func(t *testing.T) {
+		pipeline, err := parsePreprocessPipeline(`[{"step":"auto_trim","params":{"padding":100}}]`)
+		require.NoError(t, err)
+		_, err = app.preprocessImage(zap.NewNop(), bytes.NewReader(raw.Bytes()), pipeline, defaultTraceConfig())
+		require.Error(t, err)
+		assert.Equal(t, meh.ErrBadInput, meh.ErrorCode(err))
+	})
+}
+
+func BenchmarkPreprocessImage(b *testing.B) {
+	var raw bytes.Buffer
+	require.NoError(b, png.Encode(&raw, benchmarkImage(2000, 1500)))
+	options := preprocessImageOptions{
+		ApplyEXIFOrientation: true,
+		AlphaMode:            alphaModeComposite,
+		Adjust:               adjustOptions{LevelsBlack: 0.1, LevelsWhite: 0.9, Gamma: 1.2},
+		Grayscale:            grayscaleOptions{Method: grayscaleMethodLuminance},
+		EdgeDetection:        edgeDetectionOptions{Method: edgeDetectionNone},
+		AdaptiveThreshold:    adaptiveThresholdOptions{Method: adaptiveThresholdMethodNone},
+		BlurRadius:           1,
+	}
+	app := &App{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := app.preprocessImage(zap.NewNop(), bytes.NewReader(raw.Bytes()), options.pipeline(), defaultTraceConfig())
+		require.NoError(b, err)
+	}
+}
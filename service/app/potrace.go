@@ -0,0 +1,365 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/lefinal/meh"
+	"go.uber.org/zap"
+	"golang.org/x/image/bmp"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// minPotraceVersion is the oldest supported potrace version.
+var minPotraceVersion = potraceVersion{Major: 1, Minor: 16}
+
+// potraceVersion is a potrace release.
+type potraceVersion struct {
+	Major int
+	Minor int
+}
+
+func (v potraceVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// olderThan reports whether v was released before other.
+func (v potraceVersion) olderThan(other potraceVersion) bool {
+	return v.Major < other.Major || (v.Major == other.Major && v.Minor < other.Minor)
+}
+
+// potraceVersionRegexp matches the version in the output of potrace --version,
+// like "potrace 1.16. Copyright (C) 2001-2019 Peter Selinger."
+var potraceVersionRegexp = regexp.MustCompile(`^potrace (\d+)\.(\d+)`)
+
+// parsePotraceVersion parses the output of potrace --version.
+func parsePotraceVersion(output string) (potraceVersion, error) {
+	match := potraceVersionRegexp.FindStringSubmatch(strings.TrimSpace(output))
+	if match == nil {
+		return potraceVersion{}, meh.NewInternalErr("unexpected version output", meh.Details{"output": output})
+	}
+	var version potraceVersion
+	var err error
+	version.Major, err = strconv.Atoi(match[1])
+	if err != nil {
+		return potraceVersion{}, meh.NewInternalErrFromErr(err, "parse major version", meh.Details{"was": match[1]})
+	}
+	version.Minor, err = strconv.Atoi(match[2])
+	if err != nil {
+		return potraceVersion{}, meh.NewInternalErrFromErr(err, "parse minor version", meh.Details{"was": match[2]})
+	}
+	return version, nil
+}
+
+// potraceTracer traces outlines with the potrace binary.
+type potraceTracer struct {
+	// filename is the path to the potrace binary.
+	filename string
+}
+
+func (potraceTracer) modes() []string {
+	return []string{traceModeOutline}
+}
+
+// version runs potrace with --version and returns the version. An error is
+// returned if it is older than minPotraceVersion.
+func (t potraceTracer) version(ctx context.Context) (potraceVersion, error) {
+	cmd := exec.CommandContext(ctx, t.filename, "--version")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return potraceVersion{}, meh.NewInternalErrFromErr(err, "run potrace", meh.Details{"stderr": stderr.String()})
+	}
+	version, err := parsePotraceVersion(stdout.String())
+	if err != nil {
+		return potraceVersion{}, meh.Wrap(err, "parse potrace version", nil)
+	}
+	if version.olderThan(minPotraceVersion) {
+		return potraceVersion{}, meh.NewInternalErr(fmt.Sprintf("potrace %s is older than the minimum supported version %s", version, minPotraceVersion), nil)
+	}
+	return version, nil
+}
+
+func (t potraceTracer) trace(ctx context.Context, logger *zap.Logger, config TraceConfig, bm *bitmap) (tracedGeometry, error) {
+	// Convert to BMP.
+	logger.Debug("convert to bmp")
+	var imgBMP bytes.Buffer
+	err := bmp.Encode(&imgBMP, bm.gray())
+	if err != nil {
+		return tracedGeometry{}, meh.NewInternalErrFromErr(err, "encode image to bmp", nil)
+	}
+
+	// Run potrace. The bitmap is bilevel with ink in black, so any black level
+	// between black and white works.
+	args := []string{
+		"--progress",
+		"--output=-",
+		"--backend=svg",
+		"--group",
+		"--flat",
+		fmt.Sprintf("--alphamax=%.10f", config.AlphaMax),
+		fmt.Sprintf("--turdsize=%d", config.TurdSize),
+		"--turnpolicy=" + config.TurnPolicy,
+		"--blacklevel=0.5",
+		"--fill=#ffffff",
+	}
+	if config.CurveOptimizationTolerance == 0 {
+		args = append(args, "--longcurve")
+	} else {
+		args = append(args, fmt.Sprintf("--opttolerance=%.10f", config.CurveOptimizationTolerance))
+	}
+	// Read from stdin.
+	args = append(args, "-")
+	cmd := exec.CommandContext(ctx, t.filename, args...)
+	cmd.Stdin = &imgBMP
+	var svg, stderr bytes.Buffer
+	cmd.Stdout = &svg
+	cmd.Stderr = &stderr
+	start := time.Now()
+	logger.Debug("run tracing",
+		zap.String("command", t.filename),
+		zap.Strings("args", args),
+		zap.Time("start_at", start))
+	err = cmd.Run()
+	logger.Debug("stderr", zap.ByteString("stderr", stderr.Bytes()))
+	if err != nil {
+		return tracedGeometry{}, meh.NewInternalErrFromErr(err, "run potrace", meh.Details{
+			"potrace_filename": t.filename,
+			"args":             args,
+			"stderr":           stderr.String(),
+		})
+	}
+	logger.Debug("potrace done", zap.Duration("took", time.Since(start)), zap.Int("svg_bytes", svg.Len()))
+
+	geometry, err := parsePotraceSVG(&svg)
+	if err != nil {
+		return tracedGeometry{}, meh.Wrap(err, "parse potrace svg", nil)
+	}
+	return geometry, nil
+}
+
+// Define structures to capture the SVG and path data
+type SVG struct {
+	XMLName xml.Name   `xml:"svg"`
+	Width   string     `xml:"width,attr"`
+	Height  string     `xml:"height,attr"`
+	Groups  []SVGGroup `xml:"g"`
+}
+
+type SVGGroup struct {
+	XMLName   xml.Name  `xml:"g"`
+	Transform string    `xml:"transform,attr"`
+	Paths     []SVGPath `xml:"path"`
+}
+
+type SVGPath struct {
+	D string `xml:"d,attr"` // The path data (d attribute)
+}
+
+type transformOptions struct {
+	translateX float64
+	translateY float64
+	scaleX     float64
+	scaleY     float64
+}
+
+func transformOptionsFromString(s string) (transformOptions, error) {
+	opts := transformOptions{
+		translateX: 0,
+		translateY: 0,
+		scaleX:     0,
+		scaleY:     0,
+	}
+	actions := strings.Split(s, " ")
+	for _, action := range actions {
+		actionType := strings.Split(action, "(")[0]
+		param1Str := strings.Split(strings.Split(action, "(")[1], ",")[0]
+		param2Str := strings.Split(strings.Split(strings.Split(action, "(")[1], ",")[1], ")")[0]
+
+		param1, err := strconv.ParseFloat(param1Str, 64)
+		if err != nil {
+			return transformOptions{}, meh.NewInternalErrFromErr(err, "parse param 1 from action", meh.Details{"action": action})
+		}
+		param2, err := strconv.ParseFloat(param2Str, 64)
+		if err != nil {
+			return transformOptions{}, meh.NewInternalErrFromErr(err, "parse param 2 from action", meh.Details{"action": action})
+		}
+
+		switch actionType {
+		case "translate":
+			opts.translateX = param1
+			opts.translateY = param2
+		case "scale":
+			opts.scaleX = param1
+			opts.scaleY = param2
+		}
+	}
+	return opts, nil
+}
+
+// apply returns the given point in user units.
+func (opts transformOptions) apply(p point) point {
+	return point{
+		X: opts.translateX + p.X*opts.scaleX,
+		Y: opts.translateY + p.Y*opts.scaleY,
+	}
+}
+
+// parsePotraceSVG parses the paths of the given potrace SVG. SVG user units
+// equal pixels of the traced bitmap.
+func parsePotraceSVG(svgRaw io.Reader) (tracedGeometry, error) {
+	var svg SVG
+	decoder := xml.NewDecoder(svgRaw)
+	err := decoder.Decode(&svg)
+	if err != nil {
+		return tracedGeometry{}, meh.NewInternalErrFromErr(err, "parse svg", nil)
+	}
+
+	height, err := strconv.ParseFloat(strings.TrimSuffix(svg.Height, "pt"), 64)
+	if err != nil {
+		return tracedGeometry{}, meh.NewInternalErrFromErr(err, "parse svg height", meh.Details{"was": svg.Height})
+	}
+	width, err := strconv.ParseFloat(strings.TrimSuffix(svg.Width, "pt"), 64)
+	if err != nil {
+		return tracedGeometry{}, meh.NewInternalErrFromErr(err, "parse svg width", meh.Details{"was": svg.Width})
+	}
+
+	geometry := tracedGeometry{
+		Width:  int(width),
+		Height: int(height),
+		Paths:  make([]tracedPath, 0),
+	}
+	for _, group := range svg.Groups {
+		transform, err := transformOptionsFromString(group.Transform)
+		if err != nil {
+			return tracedGeometry{}, meh.Wrap(err, "parse transform options", meh.Details{"was": group.Transform})
+		}
+		for i, path := range group.Paths {
+			paths, err := parseSVGPathData(path.D, transform)
+			if err != nil {
+				return tracedGeometry{}, meh.Wrap(err, "parse path data", meh.Details{"path": i})
+			}
+			geometry.Paths = append(geometry.Paths, paths...)
+		}
+	}
+	return geometry, nil
+}
+
+// svgPathDataTokens splits SVG path data into command letters and numbers.
+func svgPathDataTokens(d string) []string {
+	tokens := make([]string, 0)
+	start := -1
+	flush := func(end int) {
+		if start != -1 {
+			tokens = append(tokens, d[start:end])
+			start = -1
+		}
+	}
+	for i, r := range d {
+		switch {
+		case unicode.IsSpace(r) || r == ',':
+			flush(i)
+		case unicode.IsLetter(r) && r != 'e' && r != 'E':
+			flush(i)
+			tokens = append(tokens, string(r))
+		case (r == '-' || r == '+') && start != -1 && d[i-1] != 'e' && d[i-1] != 'E':
+			// A sign starts a new number.
+			flush(i)
+			start = i
+		case start == -1:
+			start = i
+		}
+	}
+	flush(len(d))
+	return tokens
+}
+
+// parseSVGPathData parses SVG path data with move, line, cubic Bézier and
+// close commands as written by potrace. Each subpath becomes a separate path.
+// Points are transformed to user units.
+func parseSVGPathData(d string, transform transformOptions) ([]tracedPath, error) {
+	tokens := svgPathDataTokens(d)
+	paths := make([]tracedPath, 0)
+	var path tracedPath
+	finishPath := func() {
+		if len(path.Curves) > 0 {
+			paths = append(paths, path)
+		}
+		path = tracedPath{}
+	}
+	var current, subpathStart point
+	var command string
+	for i := 0; i < len(tokens); {
+		if unicode.IsLetter(rune(tokens[i][0])) {
+			command = tokens[i]
+			i++
+			if command == "z" || command == "Z" {
+				if current != subpathStart {
+					path.Curves = append(path.Curves, lineBezier(transform.apply(current), transform.apply(subpathStart)))
+				}
+				path.Closed = true
+				current = subpathStart
+				finishPath()
+				continue
+			}
+		}
+		var argCount int
+		switch command {
+		case "M", "m", "L", "l":
+			argCount = 2
+		case "C", "c":
+			argCount = 6
+		default:
+			return nil, meh.NewInternalErr(fmt.Sprintf("unsupported path command: %q", command), meh.Details{"token": i})
+		}
+		if i+argCount > len(tokens) {
+			return nil, meh.NewInternalErr("missing path command arguments", meh.Details{"command": command, "token": i})
+		}
+		points := make([]point, argCount/2)
+		for j := range points {
+			x, err := strconv.ParseFloat(tokens[i+2*j], 64)
+			if err != nil {
+				return nil, meh.NewInternalErrFromErr(err, "parse x", meh.Details{"was": tokens[i+2*j]})
+			}
+			y, err := strconv.ParseFloat(tokens[i+2*j+1], 64)
+			if err != nil {
+				return nil, meh.NewInternalErrFromErr(err, "parse y", meh.Details{"was": tokens[i+2*j+1]})
+			}
+			points[j] = point{X: x, Y: y}
+			if unicode.IsLower(rune(command[0])) {
+				points[j] = points[j].add(current)
+			}
+		}
+		i += argCount
+
+		switch command {
+		case "M", "m":
+			finishPath()
+			current, subpathStart = points[0], points[0]
+			// Following coordinate pairs are implicit line commands.
+			command = map[string]string{"M": "L", "m": "l"}[command]
+		case "L", "l":
+			path.Curves = append(path.Curves, lineBezier(transform.apply(current), transform.apply(points[0])))
+			current = points[0]
+		case "C", "c":
+			path.Curves = append(path.Curves, cubicBezier{
+				P0: transform.apply(current),
+				P1: transform.apply(points[0]),
+				P2: transform.apply(points[1]),
+				P3: transform.apply(points[2]),
+			})
+			current = points[2]
+		}
+	}
+	finishPath()
+	return paths, nil
+}
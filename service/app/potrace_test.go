@@ -0,0 +1,162 @@
+package app
+
+import (
+	"context"
+	"github.com/lefinal/meh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakePotrace writes a shell script to a temporary directory that behaves like
+// potrace with the given script body and returns its filename.
+func fakePotrace(t *testing.T, body string) string {
+	filename := filepath.Join(t.TempDir(), "potrace")
+	require.NoError(t, os.WriteFile(filename, []byte("#!/bin/sh\n"+body), 0o755))
+	return filename
+}
+
+func Test_potraceTracer(t *testing.T) {
+	config := TraceConfig{TurnPolicy: "minority", AlphaMax: 1, CurveOptimizationTolerance: .2, Mode: traceModeOutline}
+
+	t.Run("pipes", func(t *testing.T) {
+		argsFilename := filepath.Join(t.TempDir(), "args")
+		// Answer with the byte count of the BMP from stdin as path.
+		tracer := potraceTracer{filename: fakePotrace(t, `echo "$@" > "`+argsFilename+`"
+n=$(wc -c)
+echo progress >&2
+echo "<svg width=\"4pt\" height=\"2pt\"><g transform=\"translate(0,0) scale(1,1)\"><path d=\"M0 0 l$n 0z\"/></g></svg>"
+`)}
+		geometry, err := tracer.trace(context.Background(), zap.NewNop(), config, newBitmap(4, 2))
+		require.NoError(t, err)
+		assert.Equal(t, 4, geometry.Width)
+		require.Len(t, geometry.Paths, 1)
+		assert.Greater(t, geometry.Paths[0].Curves[0].P3.X, 0.0)
+		args, err := os.ReadFile(argsFilename)
+		require.NoError(t, err)
+		assert.Equal(t, "--progress --output=- --backend=svg --group --flat --alphamax=1.0000000000 --turdsize=0 "+
+			"--turnpolicy=minority --blacklevel=0.5 --fill=#ffffff --opttolerance=0.2000000000 -\n", string(args))
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		tracer := potraceTracer{filename: fakePotrace(t, `echo "invalid bitmap" >&2
+exit 1
+`)}
+		_, err := tracer.trace(context.Background(), zap.NewNop(), config, newBitmap(4, 2))
+		require.Error(t, err)
+		var e *meh.Error
+		require.ErrorAs(t, err, &e)
+		assert.Equal(t, meh.ErrInternal, meh.ErrorCode(err))
+		assert.Contains(t, e.Details["stderr"], "invalid bitmap")
+	})
+}
+
+func Test_parseSVGPathData(t *testing.T) {
+	identity := transformOptions{scaleX: 1, scaleY: 1}
+
+	t.Run("relative with implicit repeats", func(t *testing.T) {
+		paths, err := parseSVGPathData("M10 20 l10 0 0 10 c0 5\n-5 10 -10 10z m5 5 l1 1", identity)
+		require.NoError(t, err)
+		require.Len(t, paths, 2)
+		assert.True(t, paths[0].Closed)
+		assert.Equal(t, []cubicBezier{
+			lineBezier(point{X: 10, Y: 20}, point{X: 20, Y: 20}),
+			lineBezier(point{X: 20, Y: 20}, point{X: 20, Y: 30}),
+			{P0: point{X: 20, Y: 30}, P1: point{X: 20, Y: 35}, P2: point{X: 15, Y: 40}, P3: point{X: 10, Y: 40}},
+			lineBezier(point{X: 10, Y: 40}, point{X: 10, Y: 20}),
+		}, paths[0].Curves)
+		assert.False(t, paths[1].Closed)
+		assert.Equal(t, []cubicBezier{lineBezier(point{X: 15, Y: 25}, point{X: 16, Y: 26})}, paths[1].Curves)
+	})
+
+	t.Run("absolute without separators", func(t *testing.T) {
+		paths, err := parseSVGPathData("M1,2L3-4C5 6,7 8,9 10", identity)
+		require.NoError(t, err)
+		require.Len(t, paths, 1)
+		assert.Equal(t, []cubicBezier{
+			lineBezier(point{X: 1, Y: 2}, point{X: 3, Y: -4}),
+			{P0: point{X: 3, Y: -4}, P1: point{X: 5, Y: 6}, P2: point{X: 7, Y: 8}, P3: point{X: 9, Y: 10}},
+		}, paths[0].Curves)
+	})
+
+	t.Run("transform", func(t *testing.T) {
+		paths, err := parseSVGPathData("M0 0 l100 50", transformOptions{translateY: 10, scaleX: .1, scaleY: -.1})
+		require.NoError(t, err)
+		require.Len(t, paths, 1)
+		assert.Equal(t, lineBezier(point{X: 0, Y: 10}, point{X: 10, Y: 5}), paths[0].Curves[0])
+	})
+
+	t.Run("unsupported command", func(t *testing.T) {
+		_, err := parseSVGPathData("M0 0 q1 1 2 2", identity)
+		assert.Error(t, err)
+	})
+
+	t.Run("missing arguments", func(t *testing.T) {
+		_, err := parseSVGPathData("M0 0 c1 1 2", identity)
+		assert.Error(t, err)
+	})
+}
+
+func Test_parsePotraceSVG(t *testing.T) {
+	svg := `<?xml version="1.0" standalone="no"?>
+<svg version="1.0" xmlns="http://www.w3.org/2000/svg"
+ width="20.000000pt" height="10.000000pt" viewBox="0 0 20.000000 10.000000"
+ preserveAspectRatio="xMidYMid meet">
+<g transform="translate(0.000000,10.000000) scale(0.100000,-0.100000)"
+fill="#000000" stroke="none">
+<path fill="#000000" stroke="none" d="M0 0 l100 0 0 50 c0 30 -20 50 -50 50
+l-50 0 0 -100z"/>
+</g>
+</svg>
+`
+	geometry, err := parsePotraceSVG(strings.NewReader(svg))
+	require.NoError(t, err)
+	assert.Equal(t, 20, geometry.Width)
+	assert.Equal(t, 10, geometry.Height)
+	require.Len(t, geometry.Paths, 1)
+	curves := geometry.Paths[0].Curves
+	require.Len(t, curves, 5)
+	// The y-axis points down.
+	assert.Equal(t, lineBezier(point{X: 0, Y: 10}, point{X: 10, Y: 10}), curves[0])
+	assert.Equal(t, lineBezier(point{X: 10, Y: 10}, point{X: 10, Y: 5}), curves[1])
+	assert.InDelta(t, 2, curves[2].P1.Y, 1e-9)
+	assert.Equal(t, curves[0].P0, curves[4].P3)
+}
+
+func Test_parsePotraceVersion(t *testing.T) {
+	version, err := parsePotraceVersion("potrace 1.16. Copyright (C) 2001-2019 Peter Selinger.\nLibrary version: potracelib 1.16\n")
+	require.NoError(t, err)
+	assert.Equal(t, potraceVersion{Major: 1, Minor: 16}, version)
+	assert.Equal(t, "1.16", version.String())
+
+	_, err = parsePotraceVersion("mkbitmap 1.16")
+	assert.Error(t, err)
+
+	assert.True(t, potraceVersion{Major: 1, Minor: 9}.olderThan(minPotraceVersion))
+	assert.False(t, potraceVersion{Major: 2, Minor: 0}.olderThan(minPotraceVersion))
+}
+
+func Test_potraceTracer_version(t *testing.T) {
+	t.Run("supported", func(t *testing.T) {
+		tracer := potraceTracer{filename: fakePotrace(t, `echo "potrace 1.16. Copyright (C) 2001-2019 Peter Selinger."`)}
+		version, err := tracer.version(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, potraceVersion{Major: 1, Minor: 16}, version)
+	})
+
+	t.Run("outdated", func(t *testing.T) {
+		tracer := potraceTracer{filename: fakePotrace(t, `echo "potrace 1.8. Copyright (C) 2001-2007 Peter Selinger."`)}
+		_, err := tracer.version(context.Background())
+		assert.Error(t, err)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		tracer := potraceTracer{filename: filepath.Join(t.TempDir(), "potrace")}
+		_, err := tracer.version(context.Background())
+		assert.Error(t, err)
+	})
+}
@@ -1,96 +1,627 @@
 package app
 
 import (
-	"github.com/disintegration/gift"
+	"bytes"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/lefinal/meh"
 	"go.uber.org/zap"
 	"image"
 	"image/color"
-	"image/png"
 	"io"
 	"math"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
-type preprocessPNGOptions struct {
+type preprocessImageOptions struct {
+	ApplyEXIFOrientation bool
+	// Crop is applied if set.
+	Crop *cropRect
+	// AlphaMode is one of allowedAlphaModes.
+	AlphaMode                    string
 	TransparencyReplacementColor color.RGBA
-	BlurRadius                   float32
+	BackgroundRemoval            backgroundRemovalOptions
+	Adjust                       adjustOptions
+	Grayscale                    grayscaleOptions
+	Orientation                  transformOrientationOptions
+	AutoTrim                     autoTrimOptions
+	Resize                       resizeOptions
+	// MedianSize is the kernel size of the median filter. Zero disables it.
+	MedianSize        int
+	BlurRadius        float32
+	EdgeDetection     edgeDetectionOptions
+	AdaptiveThreshold adaptiveThresholdOptions
+	Morphology        morphologyOptions
+	// DespeckleSize is the area in pixels below which dark islands are removed. It
+	// refers to the original resolution like the trace turd size. Zero disables
+	// it.
+	DespeckleSize int
 }
 
-func preprocessPNGOptionsFromQueryParams(c *gin.Context) (preprocessPNGOptions, error) {
-	options := preprocessPNGOptions{
+// preprocessResult holds information about the preprocessing that is needed by
+// later stages.
+type preprocessResult struct {
+	// Image is the preprocessed image. It is always opaque.
+	Image image.Image
+	// Scale is the factor by which the image was resized.
+	Scale float64
+	// Bilevel is true if the image was already thresholded to black and white,
+	// which makes the black level for tracing irrelevant.
+	Bilevel bool
+	// BlackLevel is the black level from the trace config that a step used for
+	// thresholding. It is nil if no step did.
+	BlackLevel *float64
+}
+
+func preprocessImageOptionsFromQueryParams(c *gin.Context) (preprocessImageOptions, error) {
+	options := preprocessImageOptions{
+		ApplyEXIFOrientation:         true,
+		AlphaMode:                    alphaModeComposite,
 		TransparencyReplacementColor: color.RGBA{R: 255, G: 255, B: 255, A: 255},
-		BlurRadius:                   0.0,
+		Crop:                         nil,
+		BackgroundRemoval: backgroundRemovalOptions{
+			ChromaKeyColor:     nil,
+			ChromaKeyTolerance: 0.1,
+			FloodFill:          false,
+			FloodFillTolerance: 0.1,
+		},
+		Adjust: adjustOptions{
+			LevelsBlack: 0,
+			LevelsWhite: 1,
+			Gamma:       1,
+			Brightness:  0,
+			Contrast:    0,
+		},
+		Grayscale: grayscaleOptions{
+			Method:           defaultGrayscaleMethod,
+			HueMin:           0,
+			HueMax:           0,
+			HueMinSaturation: 0.2,
+		},
+		Orientation: transformOrientationOptions{
+			RotateDegrees:   0,
+			RotateFillColor: color.RGBA{R: 255, G: 255, B: 255, A: 255},
+			FlipHorizontal:  false,
+			FlipVertical:    false,
+		},
+		AutoTrim: autoTrimOptions{
+			Enabled:   false,
+			Tolerance: 0.1,
+			Padding:   0,
+		},
+		Resize: resizeOptions{
+			MaxLongEdge: 0,
+			MinLongEdge: 0,
+			MaxPixels:   0,
+			Resample:    "lanczos",
+		},
+		MedianSize: 0,
+		BlurRadius: 0.0,
+		EdgeDetection: edgeDetectionOptions{
+			Method:        edgeDetectionNone,
+			Sigma:         1.4,
+			LowThreshold:  0.1,
+			HighThreshold: 0.2,
+			Thickness:     0,
+		},
+		AdaptiveThreshold: adaptiveThresholdOptions{
+			Method:     adaptiveThresholdMethodNone,
+			WindowSize: 31,
+			Offset:     10,
+		},
+		Morphology: morphologyOptions{
+			Steps:  nil,
+			Kernel: morphologyKernelDisk,
+		},
+		DespeckleSize: 0,
 	}
 
 	var err error
+	// Parse whether to apply EXIF orientation.
+	if v := c.Query("preprocess_apply_exif_orientation"); v != "" {
+		options.ApplyEXIFOrientation, err = strconv.ParseBool(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse apply exif orientation", meh.Details{"was": v})
+		}
+	}
+
+	// Parse crop rect.
+	if v := c.Query("preprocess_crop"); v != "" {
+		crop, err := parseCropRect(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.Wrap(err, "parse crop rect", nil)
+		}
+		options.Crop = &crop
+	}
+
+	// Parse alpha mode.
+	if v := c.Query("preprocess_alpha_mode"); v != "" {
+		if !slices.Contains(allowedAlphaModes, v) {
+			return preprocessImageOptions{}, meh.NewBadInputErr(fmt.Sprintf("unsupported alpha mode: %s", v),
+				meh.Details{"allowed": allowedAlphaModes})
+		}
+		options.AlphaMode = v
+	}
+
 	// Parse replacement color.
 	if v := c.Query("preprocess_transparency_replacement_color"); v != "" {
 		options.TransparencyReplacementColor, err = parseHexRGBA(v)
 		if err != nil {
-			return preprocessPNGOptions{}, meh.NewBadInputErrFromErr(err, "parse transparency replacement color", meh.Details{"was": v})
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse transparency replacement color", meh.Details{"was": v})
 		}
 		options.TransparencyReplacementColor.A = 255
 	}
 
+	// Parse chroma key.
+	if v := c.Query("preprocess_chroma_key_color"); v != "" {
+		chromaKeyColor, err := parseHexRGBA(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse chroma key color", meh.Details{"was": v})
+		}
+		chromaKeyColor.A = 255
+		options.BackgroundRemoval.ChromaKeyColor = &chromaKeyColor
+	}
+	if v := c.Query("preprocess_chroma_key_tolerance"); v != "" {
+		options.BackgroundRemoval.ChromaKeyTolerance, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse chroma key tolerance", meh.Details{"was": v})
+		}
+		options.BackgroundRemoval.ChromaKeyTolerance = min(options.BackgroundRemoval.ChromaKeyTolerance, 1)
+		options.BackgroundRemoval.ChromaKeyTolerance = max(options.BackgroundRemoval.ChromaKeyTolerance, 0)
+	}
+
+	// Parse flood fill.
+	if v := c.Query("preprocess_flood_fill_background"); v != "" {
+		options.BackgroundRemoval.FloodFill, err = strconv.ParseBool(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse flood fill background", meh.Details{"was": v})
+		}
+	}
+	if v := c.Query("preprocess_flood_fill_tolerance"); v != "" {
+		options.BackgroundRemoval.FloodFillTolerance, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse flood fill tolerance", meh.Details{"was": v})
+		}
+		options.BackgroundRemoval.FloodFillTolerance = min(options.BackgroundRemoval.FloodFillTolerance, 1)
+		options.BackgroundRemoval.FloodFillTolerance = max(options.BackgroundRemoval.FloodFillTolerance, 0)
+	}
+
+	// Parse levels.
+	if v := c.Query("preprocess_levels_black"); v != "" {
+		options.Adjust.LevelsBlack, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse levels black", meh.Details{"was": v})
+		}
+		options.Adjust.LevelsBlack = min(options.Adjust.LevelsBlack, 1)
+		options.Adjust.LevelsBlack = max(options.Adjust.LevelsBlack, 0)
+	}
+	if v := c.Query("preprocess_levels_white"); v != "" {
+		options.Adjust.LevelsWhite, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse levels white", meh.Details{"was": v})
+		}
+		options.Adjust.LevelsWhite = min(options.Adjust.LevelsWhite, 1)
+		options.Adjust.LevelsWhite = max(options.Adjust.LevelsWhite, 0)
+	}
+	if options.Adjust.LevelsBlack >= options.Adjust.LevelsWhite {
+		return preprocessImageOptions{}, meh.NewBadInputErr("levels black must be less than levels white", meh.Details{
+			"levels_black": options.Adjust.LevelsBlack,
+			"levels_white": options.Adjust.LevelsWhite,
+		})
+	}
+
+	// Parse gamma.
+	if v := c.Query("preprocess_gamma"); v != "" {
+		options.Adjust.Gamma, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse gamma", meh.Details{"was": v})
+		}
+		options.Adjust.Gamma = min(options.Adjust.Gamma, 10)
+		options.Adjust.Gamma = max(options.Adjust.Gamma, 0.01)
+	}
+
+	// Parse brightness.
+	if v := c.Query("preprocess_brightness"); v != "" {
+		options.Adjust.Brightness, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse brightness", meh.Details{"was": v})
+		}
+		options.Adjust.Brightness = min(options.Adjust.Brightness, 100)
+		options.Adjust.Brightness = max(options.Adjust.Brightness, -100)
+	}
+
+	// Parse contrast.
+	if v := c.Query("preprocess_contrast"); v != "" {
+		options.Adjust.Contrast, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse contrast", meh.Details{"was": v})
+		}
+		options.Adjust.Contrast = min(options.Adjust.Contrast, 100)
+		options.Adjust.Contrast = max(options.Adjust.Contrast, -100)
+	}
+
+	// Parse grayscale method.
+	if v := c.Query("preprocess_grayscale"); v != "" {
+		if !slices.Contains(allowedGrayscaleMethods, v) {
+			return preprocessImageOptions{}, meh.NewBadInputErr(fmt.Sprintf("unsupported grayscale method: %s", v),
+				meh.Details{"allowed": allowedGrayscaleMethods})
+		}
+		options.Grayscale.Method = v
+	}
+
+	// Parse hue range.
+	if v := c.Query("preprocess_grayscale_hue_min"); v != "" {
+		options.Grayscale.HueMin, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse grayscale hue min", meh.Details{"was": v})
+		}
+		options.Grayscale.HueMin = min(options.Grayscale.HueMin, 360)
+		options.Grayscale.HueMin = max(options.Grayscale.HueMin, 0)
+	}
+	if v := c.Query("preprocess_grayscale_hue_max"); v != "" {
+		options.Grayscale.HueMax, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse grayscale hue max", meh.Details{"was": v})
+		}
+		options.Grayscale.HueMax = min(options.Grayscale.HueMax, 360)
+		options.Grayscale.HueMax = max(options.Grayscale.HueMax, 0)
+	}
+	if v := c.Query("preprocess_grayscale_hue_min_saturation"); v != "" {
+		options.Grayscale.HueMinSaturation, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse grayscale hue min saturation", meh.Details{"was": v})
+		}
+		options.Grayscale.HueMinSaturation = min(options.Grayscale.HueMinSaturation, 1)
+		options.Grayscale.HueMinSaturation = max(options.Grayscale.HueMinSaturation, 0)
+	}
+
+	// Parse rotation.
+	if v := c.Query("preprocess_rotate"); v != "" {
+		options.Orientation.RotateDegrees, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse rotate", meh.Details{"was": v})
+		}
+		if math.IsNaN(options.Orientation.RotateDegrees) || math.IsInf(options.Orientation.RotateDegrees, 0) {
+			return preprocessImageOptions{}, meh.NewBadInputErr("rotate must be finite", meh.Details{"was": v})
+		}
+	}
+
+	// Parse rotation fill color. Defaults to the transparency replacement color.
+	options.Orientation.RotateFillColor = options.TransparencyReplacementColor
+	if v := c.Query("preprocess_rotate_fill_color"); v != "" {
+		options.Orientation.RotateFillColor, err = parseHexRGBA(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse rotate fill color", meh.Details{"was": v})
+		}
+		options.Orientation.RotateFillColor.A = 255
+	}
+
+	// Parse flip.
+	if v := c.Query("preprocess_flip_horizontal"); v != "" {
+		options.Orientation.FlipHorizontal, err = strconv.ParseBool(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse flip horizontal", meh.Details{"was": v})
+		}
+	}
+	if v := c.Query("preprocess_flip_vertical"); v != "" {
+		options.Orientation.FlipVertical, err = strconv.ParseBool(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse flip vertical", meh.Details{"was": v})
+		}
+	}
+
+	// Parse auto trim.
+	if v := c.Query("preprocess_auto_trim"); v != "" {
+		options.AutoTrim.Enabled, err = strconv.ParseBool(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse auto trim", meh.Details{"was": v})
+		}
+	}
+	if v := c.Query("preprocess_auto_trim_tolerance"); v != "" {
+		options.AutoTrim.Tolerance, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse auto trim tolerance", meh.Details{"was": v})
+		}
+		options.AutoTrim.Tolerance = min(options.AutoTrim.Tolerance, 1)
+		options.AutoTrim.Tolerance = max(options.AutoTrim.Tolerance, 0)
+	}
+	if v := c.Query("preprocess_auto_trim_padding"); v != "" {
+		options.AutoTrim.Padding, err = strconv.Atoi(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse auto trim padding", meh.Details{"was": v})
+		}
+		options.AutoTrim.Padding = min(options.AutoTrim.Padding, maxAutoTrimPadding)
+		options.AutoTrim.Padding = max(options.AutoTrim.Padding, 0)
+	}
+
+	// Parse max long edge.
+	if v := c.Query("preprocess_max_long_edge"); v != "" {
+		options.Resize.MaxLongEdge, err = strconv.Atoi(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse max long edge", meh.Details{"was": v})
+		}
+		options.Resize.MaxLongEdge = min(options.Resize.MaxLongEdge, maxResizeLongEdge)
+		options.Resize.MaxLongEdge = max(options.Resize.MaxLongEdge, 0)
+	}
+
+	// Parse min long edge.
+	if v := c.Query("preprocess_min_long_edge"); v != "" {
+		options.Resize.MinLongEdge, err = strconv.Atoi(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse min long edge", meh.Details{"was": v})
+		}
+		options.Resize.MinLongEdge = min(options.Resize.MinLongEdge, maxResizeLongEdge)
+		options.Resize.MinLongEdge = max(options.Resize.MinLongEdge, 0)
+	}
+	if options.Resize.MaxLongEdge > 0 && options.Resize.MinLongEdge > options.Resize.MaxLongEdge {
+		return preprocessImageOptions{}, meh.NewBadInputErr("min long edge must not exceed max long edge", meh.Details{
+			"min_long_edge": options.Resize.MinLongEdge,
+			"max_long_edge": options.Resize.MaxLongEdge,
+		})
+	}
+
+	// Parse max pixels.
+	if v := c.Query("preprocess_max_pixels"); v != "" {
+		options.Resize.MaxPixels, err = strconv.Atoi(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse max pixels", meh.Details{"was": v})
+		}
+		options.Resize.MaxPixels = max(options.Resize.MaxPixels, 0)
+	}
+
+	// Parse resample filter.
+	if v := c.Query("preprocess_resample"); v != "" {
+		_, err = resampleFilterByName(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.Wrap(err, "resample filter by name", nil)
+		}
+		options.Resize.Resample = v
+	}
+
+	// Parse median size.
+	if v := c.Query("preprocess_median_size"); v != "" {
+		options.MedianSize, err = strconv.Atoi(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse median size", meh.Details{"was": v})
+		}
+		options.MedianSize = min(options.MedianSize, maxMedianSize)
+		options.MedianSize = max(options.MedianSize, 0)
+		// Kernel must be centered around the pixel.
+		if options.MedianSize > 0 && options.MedianSize%2 == 0 {
+			options.MedianSize++
+		}
+	}
+
 	// Parse blur radius.
 	if v := c.Query("preprocess_blur_radius"); v != "" {
 		f, err := strconv.ParseFloat(v, 32)
 		if err != nil {
-			return preprocessPNGOptions{}, meh.NewBadInputErrFromErr(err, "parse blur radius", meh.Details{"was": v})
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse blur radius", meh.Details{"was": v})
 		}
 		options.BlurRadius = float32(f)
 	}
 
+	// Parse edge detection.
+	if v := c.Query("preprocess_edge_detection"); v != "" {
+		if !slices.Contains(allowedEdgeDetectionMethods, v) {
+			return preprocessImageOptions{}, meh.NewBadInputErr(fmt.Sprintf("unsupported edge detection method: %s", v),
+				meh.Details{"allowed": allowedEdgeDetectionMethods})
+		}
+		options.EdgeDetection.Method = v
+	}
+	if v := c.Query("preprocess_edge_sigma"); v != "" {
+		options.EdgeDetection.Sigma, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse edge sigma", meh.Details{"was": v})
+		}
+		options.EdgeDetection.Sigma = min(options.EdgeDetection.Sigma, 20)
+		options.EdgeDetection.Sigma = max(options.EdgeDetection.Sigma, 0)
+	}
+	if v := c.Query("preprocess_edge_low_threshold"); v != "" {
+		options.EdgeDetection.LowThreshold, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse edge low threshold", meh.Details{"was": v})
+		}
+		options.EdgeDetection.LowThreshold = min(options.EdgeDetection.LowThreshold, 1)
+		options.EdgeDetection.LowThreshold = max(options.EdgeDetection.LowThreshold, 0)
+	}
+	if v := c.Query("preprocess_edge_high_threshold"); v != "" {
+		options.EdgeDetection.HighThreshold, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse edge high threshold", meh.Details{"was": v})
+		}
+		options.EdgeDetection.HighThreshold = min(options.EdgeDetection.HighThreshold, 1)
+		options.EdgeDetection.HighThreshold = max(options.EdgeDetection.HighThreshold, 0)
+	}
+	if options.EdgeDetection.LowThreshold > options.EdgeDetection.HighThreshold {
+		return preprocessImageOptions{}, meh.NewBadInputErr("edge low threshold must not exceed high threshold", meh.Details{
+			"low_threshold":  options.EdgeDetection.LowThreshold,
+			"high_threshold": options.EdgeDetection.HighThreshold,
+		})
+	}
+	if v := c.Query("preprocess_edge_thickness"); v != "" {
+		options.EdgeDetection.Thickness, err = strconv.Atoi(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse edge thickness", meh.Details{"was": v})
+		}
+		options.EdgeDetection.Thickness = min(options.EdgeDetection.Thickness, maxEdgeThickness)
+		options.EdgeDetection.Thickness = max(options.EdgeDetection.Thickness, 0)
+	}
+
+	// Parse adaptive threshold method.
+	if v := c.Query("preprocess_adaptive_threshold"); v != "" {
+		if !slices.Contains(allowedAdaptiveThresholdMethods, v) {
+			return preprocessImageOptions{}, meh.NewBadInputErr(fmt.Sprintf("unsupported adaptive threshold method: %s", v),
+				meh.Details{"allowed": allowedAdaptiveThresholdMethods})
+		}
+		options.AdaptiveThreshold.Method = v
+	}
+
+	// Parse adaptive threshold window size.
+	if v := c.Query("preprocess_adaptive_threshold_window_size"); v != "" {
+		options.AdaptiveThreshold.WindowSize, err = strconv.Atoi(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse adaptive threshold window size", meh.Details{"was": v})
+		}
+		options.AdaptiveThreshold.WindowSize = min(options.AdaptiveThreshold.WindowSize, maxAdaptiveThresholdWindowSize)
+		options.AdaptiveThreshold.WindowSize = max(options.AdaptiveThreshold.WindowSize, 3)
+		// Window must be centered around the pixel.
+		if options.AdaptiveThreshold.WindowSize%2 == 0 {
+			options.AdaptiveThreshold.WindowSize++
+		}
+	}
+
+	// Parse adaptive threshold offset.
+	if v := c.Query("preprocess_adaptive_threshold_offset"); v != "" {
+		options.AdaptiveThreshold.Offset, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse adaptive threshold offset", meh.Details{"was": v})
+		}
+		options.AdaptiveThreshold.Offset = min(options.AdaptiveThreshold.Offset, 255)
+		options.AdaptiveThreshold.Offset = max(options.AdaptiveThreshold.Offset, -255)
+	}
+
+	// Parse morphology kernel.
+	if v := c.Query("preprocess_morphology_kernel"); v != "" {
+		if !slices.Contains(allowedMorphologyKernels, v) {
+			return preprocessImageOptions{}, meh.NewBadInputErr(fmt.Sprintf("unsupported morphology kernel: %s", v),
+				meh.Details{"allowed": allowedMorphologyKernels})
+		}
+		options.Morphology.Kernel = v
+	}
+
+	// Parse morphology default radius.
+	morphologyRadius := 1
+	if v := c.Query("preprocess_morphology_radius"); v != "" {
+		morphologyRadius, err = strconv.Atoi(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse morphology radius", meh.Details{"was": v})
+		}
+	}
+
+	// Parse morphology steps.
+	if v := c.Query("preprocess_morphology"); v != "" {
+		options.Morphology.Steps, err = parseMorphologySteps(v, morphologyRadius)
+		if err != nil {
+			return preprocessImageOptions{}, meh.Wrap(err, "parse morphology steps", nil)
+		}
+	}
+
+	// Parse despeckle size.
+	if v := c.Query("preprocess_despeckle_size"); v != "" {
+		options.DespeckleSize, err = strconv.Atoi(v)
+		if err != nil {
+			return preprocessImageOptions{}, meh.NewBadInputErrFromErr(err, "parse despeckle size", meh.Details{"was": v})
+		}
+		options.DespeckleSize = min(options.DespeckleSize, 100_000_000)
+		options.DespeckleSize = max(options.DespeckleSize, 0)
+	}
+
 	return options, nil
 }
 
-func (app *App) preprocessPNG(logger *zap.Logger, r io.Reader, w io.Writer, options preprocessPNGOptions) error {
+// preprocessPipelineFromQueryParams parses the preprocessing pipeline as JSON
+// list of preprocessStepSpec from the preprocess_pipeline query param. If not
+// set, the pipeline is built from the other preprocessing query params. Both
+// must not be combined.
+func preprocessPipelineFromQueryParams(c *gin.Context) (preprocessPipeline, error) {
+	if v := c.Query("preprocess_pipeline"); v != "" {
+		conflicting := make([]string, 0)
+		for key := range c.Request.URL.Query() {
+			if strings.HasPrefix(key, "preprocess_") && key != "preprocess_pipeline" {
+				conflicting = append(conflicting, key)
+			}
+		}
+		if len(conflicting) > 0 {
+			slices.Sort(conflicting)
+			return nil, meh.NewBadInputErr("preprocess_pipeline cannot be combined with other preprocess query params",
+				meh.Details{"conflicting": conflicting})
+		}
+		pipeline, err := parsePreprocessPipeline(v)
+		if err != nil {
+			return nil, meh.Wrap(err, "parse preprocess pipeline", nil)
+		}
+		return pipeline, nil
+	}
+	options, err := preprocessImageOptionsFromQueryParams(c)
+	if err != nil {
+		return nil, meh.Wrap(err, "parse preprocess options from query params", nil)
+	}
+	return options.pipeline(), nil
+}
+
+// preprocessImage decodes the image from r and runs the given pipeline. Images
+// that are not opaque after the pipeline are composited over white. Steps that
+// threshold the image use the black level from the given trace config.
+func (app *App) preprocessImage(logger *zap.Logger, r io.Reader, pipeline preprocessPipeline, traceConfig TraceConfig) (preprocessResult, error) {
 	start := time.Now()
 	logger.Debug("start preprocessing")
 	defer func() {
 		logger.Debug("finished preprocessing", zap.Duration("took", time.Since(start)))
 	}()
 
-	pngImage, err := png.Decode(r)
+	raw, err := io.ReadAll(r)
 	if err != nil {
-		return meh.NewBadInputErrFromErr(err, "parse png", nil)
-	}
-
-	// Recolor transparency.
-	bounds := pngImage.Bounds()
-	newImage := image.NewRGBA(bounds)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			original := pngImage.At(x, y)
-			r, g, b, a := original.RGBA() // Returns values in range 0-65535
-
-			if a < math.MaxUint16 { // If the pixel is not fully opaque
-				newImage.Set(x, y, options.TransparencyReplacementColor)
-			} else {
-				newImage.Set(x, y, color.RGBA{
-					R: uint8(r >> 8),
-					G: uint8(g >> 8),
-					B: uint8(b >> 8),
-					A: 255})
-			}
-		}
+		return preprocessResult{}, meh.NewBadInputErrFromErr(err, "read image", nil)
+	}
+	limits := imageLimits{
+		MaxWidth:  app.config.MaxImageWidth,
+		MaxHeight: app.config.MaxImageHeight,
+		MaxPixels: app.config.MaxImagePixels,
 	}
+	err = checkImageLimits(bytes.NewReader(raw), limits)
+	if err != nil {
+		return preprocessResult{}, meh.Wrap(err, "check image limits", nil)
+	}
+	img, format, err := decodeImage(bytes.NewReader(raw))
+	if err != nil {
+		return preprocessResult{}, meh.Wrap(err, "decode image", nil)
+	}
+	logger.Debug("decoded image", zap.String("format", format))
 
-	// Apply Gaussian Blur.
-	if options.BlurRadius > 0 {
-		blurredImage := image.NewRGBA(bounds)
-		g := gift.New(gift.GaussianBlur(options.BlurRadius))
-		g.Draw(blurredImage, newImage)
-		newImage = blurredImage
+	state := &preprocessState{
+		Logger:      logger,
+		Raw:         raw,
+		Scale:       1,
+		Bilevel:     false,
+		TraceConfig: traceConfig,
+		BlackLevel:  nil,
+		Limits:      limits,
+	}
+	for i, step := range pipeline {
+		stepStart := time.Now()
+		img, err = step.Step.apply(state, img)
+		if err != nil {
+			return preprocessResult{}, meh.Wrap(err, "apply preprocess step", meh.Details{
+				"step_index": i,
+				"step":       step.Name,
+			})
+		}
+		// Steps like rotating or padding enlarge the image.
+		size := img.Bounds().Size()
+		err = limits.check(size.X, size.Y)
+		if err != nil {
+			return preprocessResult{}, meh.Wrap(err, "check image limits after preprocess step", meh.Details{
+				"step_index": i,
+				"step":       step.Name,
+			})
+		}
+		logger.Debug("applied preprocess step",
+			zap.String("step", step.Name),
+			zap.Duration("took", time.Since(stepStart)))
 	}
 
-	// Encode PNG.
-	err = png.Encode(w, newImage)
-	if err != nil {
-		return meh.NewInternalErrFromErr(err, "encode png", nil)
+	// Potrace does not know about transparency.
+	if opaque, ok := img.(interface{ Opaque() bool }); !ok || !opaque.Opaque() {
+		img = compositeOverColor(img, defaultReplacementColor)
 	}
-	return nil
+	return preprocessResult{
+		Image:      img,
+		Scale:      state.Scale,
+		Bilevel:    state.Bilevel && isBilevel(img),
+		BlackLevel: state.BlackLevel,
+	}, nil
 }
@@ -0,0 +1,306 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lefinal/meh"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// colorModeSingle traces the image once and draws all paths with the stroke
+	// color.
+	colorModeSingle = "single"
+	// colorModeQuantize reduces the image to a few colors and traces each color
+	// separately.
+	colorModeQuantize = "quantize"
+)
+
+var allowedColorModes = []string{colorModeSingle, colorModeQuantize}
+
+// maxQuantizeColors limits the number of colors for quantization.
+const maxQuantizeColors = 16
+
+// quantizeOptions configures splitting an image into color layers.
+type quantizeOptions struct {
+	// Mode is one of allowedColorModes.
+	Mode string
+	// Colors is the number of colors to reduce the image to if no Palette is set.
+	Colors int
+	// Palette to map the image to. If set, Colors is ignored.
+	Palette []color.RGBA
+	// IncludeBackground traces the layer that covers most of the image border as
+	// well.
+	IncludeBackground bool
+}
+
+func quantizeOptionsFromQueryParams(c *gin.Context) (quantizeOptions, error) {
+	options := quantizeOptions{
+		Mode:              colorModeSingle,
+		Colors:            4,
+		Palette:           nil,
+		IncludeBackground: false,
+	}
+
+	var err error
+	// Parse color mode.
+	if v := c.Query("trace_color_mode"); v != "" {
+		if !slices.Contains(allowedColorModes, v) {
+			return quantizeOptions{}, meh.NewBadInputErr(fmt.Sprintf("unsupported color mode: %s", v),
+				meh.Details{"allowed": allowedColorModes})
+		}
+		options.Mode = v
+	}
+
+	// Parse color count.
+	if v := c.Query("trace_quantize_colors"); v != "" {
+		options.Colors, err = strconv.Atoi(v)
+		if err != nil {
+			return quantizeOptions{}, meh.NewBadInputErrFromErr(err, "parse quantize colors", meh.Details{"was": v})
+		}
+		options.Colors = min(options.Colors, maxQuantizeColors)
+		options.Colors = max(options.Colors, 2)
+	}
+
+	// Parse palette.
+	if v := c.Query("trace_quantize_palette"); v != "" {
+		hexColors := strings.Split(v, ",")
+		if len(hexColors) > maxQuantizeColors {
+			return quantizeOptions{}, meh.NewBadInputErr("too many palette colors", meh.Details{
+				"was": len(hexColors),
+				"max": maxQuantizeColors,
+			})
+		}
+		for _, hexColor := range hexColors {
+			paletteColor, err := parseHexRGBA(strings.TrimSpace(hexColor))
+			if err != nil {
+				return quantizeOptions{}, meh.NewBadInputErrFromErr(err, "parse palette color", meh.Details{"was": hexColor})
+			}
+			paletteColor.A = 255
+			options.Palette = append(options.Palette, paletteColor)
+		}
+	}
+
+	// Parse include background.
+	if v := c.Query("trace_quantize_include_background"); v != "" {
+		options.IncludeBackground, err = strconv.ParseBool(v)
+		if err != nil {
+			return quantizeOptions{}, meh.NewBadInputErrFromErr(err, "parse quantize include background", meh.Details{"was": v})
+		}
+	}
+
+	return options, nil
+}
+
+// colorLayer is an image that is traced separately and drawn with its own
+// color.
+type colorLayer struct {
+	Color color.RGBA
+	// Image is the image to trace.
+	Image image.Image
+	// IsMask is true if Image already marks the pixels of Color as black ink.
+	// Such layers are never inverted, as this would trace all other colors.
+	IsMask bool
+}
+
+// quantizeSampleCount is the maximum number of pixels used for finding the
+// palette with k-means.
+const quantizeSampleCount = 20_000
+
+// quantizeIterations is the number of k-means iterations.
+const quantizeIterations = 15
+
+// kMeansPalette finds a palette of up to k colors for the given image using
+// k-means clustering in RGB on a sample of pixels. The result is
+// deterministic. Fewer colors are returned if the image has fewer distinct
+// colors.
+func kMeansPalette(img image.Image, k int) []color.RGBA {
+	src := toRGBA(img)
+	width := src.Bounds().Dx()
+	pixelCount := width * src.Bounds().Dy()
+	if pixelCount == 0 {
+		return nil
+	}
+	step := max(1, pixelCount/quantizeSampleCount)
+	samples := make([][3]float64, 0, min(pixelCount, quantizeSampleCount+1))
+	for i := 0; i < pixelCount; i += step {
+		offset := (i/width)*src.Stride + 4*(i%width)
+		samples = append(samples, [3]float64{float64(src.Pix[offset]), float64(src.Pix[offset+1]), float64(src.Pix[offset+2])})
+	}
+	sqDist := func(a, b [3]float64) float64 {
+		return (a[0]-b[0])*(a[0]-b[0]) + (a[1]-b[1])*(a[1]-b[1]) + (a[2]-b[2])*(a[2]-b[2])
+	}
+
+	// Initialize centers with k-means++.
+	rng := rand.New(rand.NewSource(1))
+	centers := [][3]float64{samples[rng.Intn(len(samples))]}
+	distances := make([]float64, len(samples))
+	for len(centers) < k {
+		total := 0.0
+		for i, sample := range samples {
+			distances[i] = math.Inf(1)
+			for _, center := range centers {
+				distances[i] = min(distances[i], sqDist(sample, center))
+			}
+			total += distances[i]
+		}
+		if total == 0 {
+			// All samples are covered by existing centers.
+			break
+		}
+		target := rng.Float64() * total
+		chosen := len(samples) - 1
+		for i, d := range distances {
+			target -= d
+			if target <= 0 && d > 0 {
+				chosen = i
+				break
+			}
+		}
+		centers = append(centers, samples[chosen])
+	}
+
+	// Lloyd iterations.
+	assignments := make([]int, len(samples))
+	for range quantizeIterations {
+		for i, sample := range samples {
+			assignments[i] = nearestCenter(sample, centers)
+		}
+		sums := make([][3]float64, len(centers))
+		counts := make([]int, len(centers))
+		for i, sample := range samples {
+			for channel := range 3 {
+				sums[assignments[i]][channel] += sample[channel]
+			}
+			counts[assignments[i]]++
+		}
+		for i := range centers {
+			if counts[i] == 0 {
+				continue
+			}
+			for channel := range 3 {
+				centers[i][channel] = sums[i][channel] / float64(counts[i])
+			}
+		}
+	}
+
+	palette := make([]color.RGBA, 0, len(centers))
+	for _, center := range centers {
+		palette = append(palette, color.RGBA{
+			R: uint8(math.Round(center[0])),
+			G: uint8(math.Round(center[1])),
+			B: uint8(math.Round(center[2])),
+			A: 255,
+		})
+	}
+	return palette
+}
+
+// nearestCenter returns the index of the center that is closest to the given
+// color.
+func nearestCenter(c [3]float64, centers [][3]float64) int {
+	nearest := 0
+	nearestDist := math.Inf(1)
+	for i, center := range centers {
+		d := (c[0]-center[0])*(c[0]-center[0]) + (c[1]-center[1])*(c[1]-center[1]) + (c[2]-center[2])*(c[2]-center[2])
+		if d < nearestDist {
+			nearest = i
+			nearestDist = d
+		}
+	}
+	return nearest
+}
+
+// quantizeImage maps each pixel of the given image to the nearest palette
+// color.
+func quantizeImage(img image.Image, palette []color.RGBA) *image.Paletted {
+	centers := make([][3]float64, 0, len(palette))
+	p := make(color.Palette, 0, len(palette))
+	for _, c := range palette {
+		centers = append(centers, [3]float64{float64(c.R), float64(c.G), float64(c.B)})
+		p = append(p, c)
+	}
+	src := toRGBA(img)
+	width := src.Bounds().Dx()
+	quantized := image.NewPaletted(src.Bounds(), p)
+	parallelRows(src.Bounds().Dy(), func(minY, maxY int) {
+		for y := minY; y < maxY; y++ {
+			srcRow := src.Pix[y*src.Stride : y*src.Stride+4*width]
+			quantizedRow := quantized.Pix[y*quantized.Stride : y*quantized.Stride+width]
+			for x := range quantizedRow {
+				c := [3]float64{float64(srcRow[4*x]), float64(srcRow[4*x+1]), float64(srcRow[4*x+2])}
+				quantizedRow[x] = uint8(nearestCenter(c, centers))
+			}
+		}
+	})
+	return quantized
+}
+
+// borderMajorityIndex returns the palette index that most border pixels of the
+// given image have.
+func borderMajorityIndex(img *image.Paletted) int {
+	bounds := img.Bounds()
+	counts := make([]int, len(img.Palette))
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		counts[img.ColorIndexAt(x, bounds.Min.Y)]++
+		counts[img.ColorIndexAt(x, bounds.Max.Y-1)]++
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		counts[img.ColorIndexAt(bounds.Min.X, y)]++
+		counts[img.ColorIndexAt(bounds.Max.X-1, y)]++
+	}
+	majority := 0
+	for i, count := range counts {
+		if count > counts[majority] {
+			majority = i
+		}
+	}
+	return majority
+}
+
+// splitColorLayers quantizes the given image and creates a bilevel layer for
+// each color where pixels of this color are black.
+func splitColorLayers(img image.Image, options quantizeOptions) []colorLayer {
+	palette := options.Palette
+	if len(palette) == 0 {
+		palette = kMeansPalette(img, options.Colors)
+	}
+	quantized := quantizeImage(img, palette)
+	background := -1
+	if !options.IncludeBackground {
+		background = borderMajorityIndex(quantized)
+	}
+	bounds := quantized.Bounds()
+	layers := make([]colorLayer, 0, len(palette))
+	for i, layerColor := range palette {
+		if i == background {
+			continue
+		}
+		mask := image.NewGray(bounds)
+		empty := true
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if quantized.Pix[quantized.PixOffset(x, y)] == uint8(i) {
+					empty = false
+				} else {
+					mask.Pix[mask.PixOffset(x, y)] = math.MaxUint8
+				}
+			}
+		}
+		if empty {
+			continue
+		}
+		layers = append(layers, colorLayer{
+			Color:  layerColor,
+			Image:  mask,
+			IsMask: true,
+		})
+	}
+	return layers
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_splitColorLayers(t *testing.T) {
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	// White background with a red square on the left and a blue one on the right.
+	img := image.NewRGBA(image.Rect(0, 0, 10, 6))
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 10; x++ {
+			switch {
+			case y >= 1 && y < 5 && x >= 1 && x < 4:
+				img.SetRGBA(x, y, red)
+			case y >= 1 && y < 5 && x >= 6 && x < 9:
+				img.SetRGBA(x, y, blue)
+			default:
+				img.SetRGBA(x, y, white)
+			}
+		}
+	}
+
+	t.Run("k-means palette", func(t *testing.T) {
+		palette := kMeansPalette(img, 8)
+		assert.ElementsMatch(t, []color.RGBA{white, red, blue}, palette, "should only find distinct colors")
+	})
+
+	t.Run("skip background", func(t *testing.T) {
+		layers := splitColorLayers(img, quantizeOptions{Mode: colorModeQuantize, Colors: 3})
+		layerColors := make([]color.RGBA, 0)
+		for _, layer := range layers {
+			layerColors = append(layerColors, layer.Color)
+		}
+		assert.ElementsMatch(t, []color.RGBA{red, blue}, layerColors)
+	})
+
+	t.Run("palette with background", func(t *testing.T) {
+		darkRed := color.RGBA{R: 200, A: 255}
+		layers := splitColorLayers(img, quantizeOptions{
+			Mode:              colorModeQuantize,
+			Palette:           []color.RGBA{white, darkRed, blue},
+			IncludeBackground: true,
+		})
+		require.Len(t, layers, 3)
+		assert.Equal(t, darkRed, layers[1].Color)
+		mask := layers[1].Image
+		assert.Equal(t, uint8(0), color.GrayModel.Convert(mask.At(2, 2)).(color.Gray).Y, "red pixels should be black")
+		assert.Equal(t, uint8(255), color.GrayModel.Convert(mask.At(7, 2)).(color.Gray).Y, "blue pixels should be white")
+	})
+}
+
+func BenchmarkSplitColorLayers(b *testing.B) {
+	img := benchmarkImage(2000, 1500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		splitColorLayers(img, quantizeOptions{Mode: colorModeQuantize, Colors: 4})
+	}
+}
@@ -0,0 +1,208 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/lefinal/image-to-ma3-scribble/web"
+	"github.com/lefinal/meh"
+	"go.uber.org/zap"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tracePriority is the priority of a trace job in the traceQueue.
+type tracePriority int
+
+const (
+	// tracePriorityLow is for previews. They are requested frequently while
+	// adjusting settings.
+	tracePriorityLow tracePriority = iota
+	// tracePriorityHigh is for downloads.
+	tracePriorityHigh
+)
+
+func (priority tracePriority) String() string {
+	if priority == tracePriorityHigh {
+		return "high"
+	}
+	return "low"
+}
+
+const (
+	// DefaultMaxQueuedTraces is the default for Config.MaxQueuedTraces.
+	DefaultMaxQueuedTraces = 16
+	// traceQueueMaxWait is the maximum time a job waits in the traceQueue. It
+	// leaves time for the actual work before the server write timeout kicks in.
+	traceQueueMaxWait = 5 * time.Second
+	// traceQueueRetryAfter is the time after which clients should retry if the
+	// traceQueue is full.
+	traceQueueRetryAfter = 5 * time.Second
+)
+
+// traceQueueTicket is a job waiting in the traceQueue.
+type traceQueueTicket struct {
+	// done receives nil once the job is admitted or an error if it was dropped.
+	done chan error
+}
+
+// traceQueue limits the number of concurrently running trace jobs. Jobs that
+// find all slots busy wait in a bounded queue and are admitted by priority and
+// then in order of arrival.
+type traceQueue struct {
+	maxRunning int
+	maxWaiting int
+	// maxWait is the maximum time a job waits for being admitted.
+	maxWait time.Duration
+
+	mutex   sync.Mutex
+	running int
+	// waiting holds the waiting jobs for each priority in order of arrival.
+	waiting [tracePriorityHigh + 1][]*traceQueueTicket
+}
+
+func newTraceQueue(maxRunning, maxWaiting int) *traceQueue {
+	return &traceQueue{
+		maxRunning: max(maxRunning, 1),
+		maxWaiting: max(maxWaiting, 0),
+		maxWait:    traceQueueMaxWait,
+	}
+}
+
+// depth returns the number of waiting jobs. The mutex must be locked.
+func (q *traceQueue) depth() int {
+	depth := 0
+	for _, tickets := range q.waiting {
+		depth += len(tickets)
+	}
+	return depth
+}
+
+// layerConcurrency returns the number of color layers a running job may trace
+// at the same time. It is the job's share of the CPUs, so that all running jobs
+// together do not use more than that.
+func (q *traceQueue) layerConcurrency() int {
+	return max(runtime.NumCPU()/q.maxRunning, 1)
+}
+
+// acquire waits until the job may run and returns the function for releasing
+// the slot afterward. If the queue is full or the job waited longer than
+// maxWait, an error with web.ErrUnavailable is returned. Jobs with
+// high priority displace the most recent waiting job with low priority if the
+// queue is full.
+func (q *traceQueue) acquire(ctx context.Context, logger *zap.Logger, priority tracePriority) (func(), error) {
+	q.mutex.Lock()
+	if q.running < q.maxRunning {
+		q.running++
+		logger.Debug("trace admitted",
+			zap.Int("running", q.running),
+			zap.Int("queue_depth", q.depth()))
+		q.mutex.Unlock()
+		return q.release, nil
+	}
+	if q.depth() >= q.maxWaiting {
+		lowWaiting := q.waiting[tracePriorityLow]
+		if priority == tracePriorityLow || len(lowWaiting) == 0 {
+			depth := q.depth()
+			q.mutex.Unlock()
+			return nil, meh.NewErr(web.ErrUnavailable, "trace queue full", meh.Details{
+				"queue_depth": depth,
+				"priority":    priority.String(),
+			})
+		}
+		lowWaiting[len(lowWaiting)-1].done <- meh.NewErr(web.ErrUnavailable, "displaced from trace queue by job with higher priority", nil)
+		q.waiting[tracePriorityLow] = lowWaiting[:len(lowWaiting)-1]
+	}
+	ticket := &traceQueueTicket{done: make(chan error, 1)}
+	q.waiting[priority] = append(q.waiting[priority], ticket)
+	logger.Info("trace queued",
+		zap.String("priority", priority.String()),
+		zap.Int("running", q.running),
+		zap.Int("queue_depth", q.depth()))
+	q.mutex.Unlock()
+
+	start := time.Now()
+	timeout, cancel := context.WithTimeout(ctx, q.maxWait)
+	defer cancel()
+	select {
+	case err := <-ticket.done:
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug("trace admitted from queue", zap.Duration("waited", time.Since(start)))
+		return q.release, nil
+	case <-timeout.Done():
+	}
+
+	// Leave the queue unless admitted or dropped in the meantime.
+	q.mutex.Lock()
+	removed := q.remove(priority, ticket)
+	q.mutex.Unlock()
+	if !removed {
+		err := <-ticket.done
+		if err != nil {
+			return nil, err
+		}
+		q.release()
+	}
+	if errors.Is(timeout.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return nil, meh.NewErr(web.ErrUnavailable, "timeout while waiting in trace queue", meh.Details{"waited": time.Since(start).String()})
+	}
+	return nil, meh.NewInternalErrFromErr(ctx.Err(), "wait in trace queue", nil)
+}
+
+// remove removes the given ticket from the waiting ones. The mutex must be
+// locked. It reports whether the ticket was still waiting.
+func (q *traceQueue) remove(priority tracePriority, ticket *traceQueueTicket) bool {
+	for i, waiting := range q.waiting[priority] {
+		if waiting == ticket {
+			q.waiting[priority] = append(q.waiting[priority][:i], q.waiting[priority][i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// release hands the slot to the next waiting job or frees it.
+func (q *traceQueue) release() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for priority := tracePriorityHigh; priority >= tracePriorityLow; priority-- {
+		if len(q.waiting[priority]) == 0 {
+			continue
+		}
+		next := q.waiting[priority][0]
+		q.waiting[priority] = q.waiting[priority][1:]
+		next.done <- nil
+		return
+	}
+	q.running--
+}
+
+// queued wraps the given handler, so that it only runs once admitted by the
+// trace queue. The request body is read beforehand, so that waiting does not eat
+// up the time for uploading. If the queue is full, clients are asked to retry
+// later.
+func (app *App) queued(priority tracePriority, handler web.HandlerFunc) web.HandlerFunc {
+	return func(logger *zap.Logger, c *gin.Context) error {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return meh.NewBadInputErrFromErr(err, "read body", nil)
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		release, err := app.traceQueue.acquire(c.Request.Context(), logger, priority)
+		if err != nil {
+			if meh.ErrorCode(err) == web.ErrUnavailable {
+				c.Header("Retry-After", strconv.Itoa(int(traceQueueRetryAfter.Seconds())))
+			}
+			return meh.Wrap(err, "wait for trace slot", nil)
+		}
+		defer release()
+		return handler(logger, c)
+	}
+}
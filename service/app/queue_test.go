@@ -0,0 +1,120 @@
+package app
+
+import (
+	"context"
+	"github.com/lefinal/image-to-ma3-scribble/web"
+	"github.com/lefinal/meh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// acquireAsync acquires a slot in the background and releases it right away.
+// The result is sent to the returned channel.
+func acquireAsync(q *traceQueue, priority tracePriority) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		release, err := q.acquire(context.Background(), zap.NewNop(), priority)
+		if err == nil {
+			release()
+		}
+		result <- err
+	}()
+	return result
+}
+
+// waitForDepth waits until the given number of jobs waits in the queue.
+func waitForDepth(t *testing.T, q *traceQueue, depth int) {
+	assert.Eventually(t, func() bool {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		return q.depth() == depth
+	}, time.Second, time.Millisecond)
+}
+
+func Test_traceQueue(t *testing.T) {
+	ctx := context.Background()
+	logger := zap.NewNop()
+
+	t.Run("full", func(t *testing.T) {
+		q := newTraceQueue(1, 1)
+		release, err := q.acquire(ctx, logger, tracePriorityHigh)
+		require.NoError(t, err)
+		queued := acquireAsync(q, tracePriorityLow)
+		waitForDepth(t, q, 1)
+
+		_, err = q.acquire(ctx, logger, tracePriorityLow)
+		assert.Equal(t, web.ErrUnavailable, meh.ErrorCode(err))
+
+		release()
+		assert.NoError(t, <-queued)
+		assert.Equal(t, 0, q.running)
+	})
+
+	t.Run("priority", func(t *testing.T) {
+		q := newTraceQueue(1, 2)
+		release, err := q.acquire(ctx, logger, tracePriorityHigh)
+		require.NoError(t, err)
+		admitted := make(chan tracePriority, 2)
+		for _, priority := range []tracePriority{tracePriorityLow, tracePriorityHigh} {
+			go func() {
+				release, err := q.acquire(ctx, logger, priority)
+				assert.NoError(t, err)
+				admitted <- priority
+				release()
+			}()
+			waitForDepth(t, q, int(priority)+1)
+		}
+
+		release()
+		assert.Equal(t, tracePriorityHigh, <-admitted)
+		assert.Equal(t, tracePriorityLow, <-admitted)
+	})
+
+	t.Run("displace low priority", func(t *testing.T) {
+		q := newTraceQueue(1, 1)
+		release, err := q.acquire(ctx, logger, tracePriorityHigh)
+		require.NoError(t, err)
+		low := acquireAsync(q, tracePriorityLow)
+		waitForDepth(t, q, 1)
+		high := acquireAsync(q, tracePriorityHigh)
+
+		assert.Equal(t, web.ErrUnavailable, meh.ErrorCode(<-low))
+		release()
+		assert.NoError(t, <-high)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		q := newTraceQueue(1, 1)
+		q.maxWait = 10 * time.Millisecond
+		release, err := q.acquire(ctx, logger, tracePriorityHigh)
+		require.NoError(t, err)
+
+		_, err = q.acquire(ctx, logger, tracePriorityHigh)
+		assert.Equal(t, web.ErrUnavailable, meh.ErrorCode(err))
+		assert.Equal(t, 0, q.depth())
+		release()
+		assert.Equal(t, 0, q.running)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		q := newTraceQueue(1, 1)
+		release, err := q.acquire(ctx, logger, tracePriorityHigh)
+		require.NoError(t, err)
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err = q.acquire(canceled, logger, tracePriorityHigh)
+		assert.Equal(t, meh.ErrInternal, meh.ErrorCode(err))
+		release()
+		assert.Equal(t, 0, q.running)
+	})
+
+	t.Run("layer concurrency", func(t *testing.T) {
+		assert.Equal(t, runtime.NumCPU(), newTraceQueue(1, 0).layerConcurrency())
+		assert.Equal(t, 1, newTraceQueue(runtime.NumCPU()*2, 0).layerConcurrency())
+	})
+}
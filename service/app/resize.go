@@ -0,0 +1,132 @@
+package app
+
+import (
+	"fmt"
+	"github.com/disintegration/gift"
+	"github.com/lefinal/meh"
+	"image"
+	"math"
+)
+
+// maxResizeLongEdge limits the long edge that may be requested for resizing.
+const maxResizeLongEdge = 16_384
+
+var allowedResampleFilters = []string{"lanczos", "cubic", "linear", "box", "nearest"}
+
+// resampleFilterByName returns the gift.Resampling for the given name from
+// allowedResampleFilters.
+func resampleFilterByName(name string) (gift.Resampling, error) {
+	switch name {
+	case "lanczos":
+		return gift.LanczosResampling, nil
+	case "cubic":
+		return gift.CubicResampling, nil
+	case "linear":
+		return gift.LinearResampling, nil
+	case "box":
+		return gift.BoxResampling, nil
+	case "nearest":
+		return gift.NearestNeighborResampling, nil
+	default:
+		return nil, meh.NewBadInputErr(fmt.Sprintf("unsupported resample filter: %s", name),
+			meh.Details{"allowed": allowedResampleFilters})
+	}
+}
+
+// resizeOptions describes the target size of an image.
+type resizeOptions struct {
+	// MaxLongEdge downscales images with a longer edge than this. Zero means no
+	// limit.
+	MaxLongEdge int
+	// MinLongEdge upscales images with a shorter long edge than this. Zero means no
+	// limit.
+	MinLongEdge int
+	// MaxPixels is the total pixel budget. It takes precedence over MinLongEdge.
+	// Zero means no limit.
+	MaxPixels int
+	// MaxWidth and MaxHeight cap the dimensions of the resized image. Like
+	// MaxPixels, they take precedence over MinLongEdge. Zero means no limit.
+	MaxWidth  int
+	MaxHeight int
+	// Resample is the name of the resampling filter from allowedResampleFilters.
+	Resample string
+}
+
+// resizeScale calculates the factor to scale an image with the given size so
+// that it satisfies the resizeOptions. A factor of 1 means no resizing.
+func (options resizeOptions) resizeScale(size image.Point) float64 {
+	if size.X <= 0 || size.Y <= 0 {
+		return 1
+	}
+	longEdge := float64(max(size.X, size.Y))
+	scale := 1.0
+	if options.MaxLongEdge > 0 && longEdge > float64(options.MaxLongEdge) {
+		scale = float64(options.MaxLongEdge) / longEdge
+	}
+	if options.MinLongEdge > 0 && longEdge < float64(options.MinLongEdge) {
+		scale = float64(options.MinLongEdge) / longEdge
+	}
+	pixels := float64(size.X) * float64(size.Y)
+	if options.MaxPixels > 0 && pixels*scale*scale > float64(options.MaxPixels) {
+		scale = math.Sqrt(float64(options.MaxPixels) / pixels)
+	}
+	if options.MaxWidth > 0 && float64(size.X)*scale > float64(options.MaxWidth) {
+		scale = float64(options.MaxWidth) / float64(size.X)
+	}
+	if options.MaxHeight > 0 && float64(size.Y)*scale > float64(options.MaxHeight) {
+		scale = float64(options.MaxHeight) / float64(size.Y)
+	}
+	return scale
+}
+
+// isNeutral checks whether the resizeOptions never change the image size.
+func (options resizeOptions) isNeutral() bool {
+	return options.MaxLongEdge == 0 && options.MinLongEdge == 0 && options.MaxPixels == 0
+}
+
+// withinLimits returns the options with the pixel budget and dimensions capped
+// to the given limits, so that upscaling cannot produce images exceeding them.
+// The scale is clamped before allocating the resized image.
+func (options resizeOptions) withinLimits(limits imageLimits) resizeOptions {
+	if limits.MaxPixels > 0 && (options.MaxPixels == 0 || options.MaxPixels > limits.MaxPixels) {
+		options.MaxPixels = limits.MaxPixels
+	}
+	if limits.MaxWidth > 0 && (options.MaxWidth == 0 || options.MaxWidth > limits.MaxWidth) {
+		options.MaxWidth = limits.MaxWidth
+	}
+	if limits.MaxHeight > 0 && (options.MaxHeight == 0 || options.MaxHeight > limits.MaxHeight) {
+		options.MaxHeight = limits.MaxHeight
+	}
+	return options
+}
+
+// resizeImage resizes the given image according to the resizeOptions. It
+// returns the resized image and the applied scale factor.
+func resizeImage(img image.Image, options resizeOptions) (image.Image, float64, error) {
+	size := img.Bounds().Size()
+	scale := options.resizeScale(size)
+	newWidth := max(1, int(math.Round(float64(size.X)*scale)))
+	newHeight := max(1, int(math.Round(float64(size.Y)*scale)))
+	// Rounding up must not exceed the pixel budget.
+	if options.MaxPixels > 0 && newWidth*newHeight > options.MaxPixels {
+		newWidth = max(1, int(float64(size.X)*scale))
+		newHeight = max(1, int(float64(size.Y)*scale))
+	}
+	if options.MaxWidth > 0 {
+		newWidth = min(newWidth, options.MaxWidth)
+	}
+	if options.MaxHeight > 0 {
+		newHeight = min(newHeight, options.MaxHeight)
+	}
+	if newWidth == size.X && newHeight == size.Y {
+		return img, 1, nil
+	}
+	resampling, err := resampleFilterByName(options.Resample)
+	if err != nil {
+		return nil, 0, meh.Wrap(err, "resample filter by name", nil)
+	}
+	g := gift.New(gift.Resize(newWidth, newHeight, resampling))
+	dst := image.NewRGBA(g.Bounds(img.Bounds()))
+	g.Draw(dst, img)
+	return dst, float64(newWidth) / float64(size.X), nil
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image"
+	"testing"
+)
+
+func Test_resizeOptions_resizeScale(t *testing.T) {
+	tests := []struct {
+		name    string
+		options resizeOptions
+		size    image.Point
+		expect  float64
+	}{
+		{
+			name:    "no limits",
+			options: resizeOptions{},
+			size:    image.Pt(6000, 4000),
+			expect:  1,
+		},
+		{
+			name:    "max long edge",
+			options: resizeOptions{MaxLongEdge: 3000},
+			size:    image.Pt(4000, 6000),
+			expect:  0.5,
+		},
+		{
+			name:    "max long edge not exceeded",
+			options: resizeOptions{MaxLongEdge: 3000},
+			size:    image.Pt(400, 600),
+			expect:  1,
+		},
+		{
+			name:    "min long edge",
+			options: resizeOptions{MinLongEdge: 256},
+			size:    image.Pt(64, 32),
+			expect:  4,
+		},
+		{
+			name:    "max pixels",
+			options: resizeOptions{MaxPixels: 100},
+			size:    image.Pt(40, 10),
+			expect:  0.5,
+		},
+		{
+			name:    "max pixels precedence over min long edge",
+			options: resizeOptions{MinLongEdge: 1000, MaxPixels: 1600},
+			size:    image.Pt(10, 10),
+			expect:  4,
+		},
+		{
+			name:    "max width precedence over min long edge",
+			options: resizeOptions{MinLongEdge: 1000, MaxWidth: 200},
+			size:    image.Pt(100, 50),
+			expect:  2,
+		},
+		{
+			name:    "max height precedence over min long edge",
+			options: resizeOptions{MinLongEdge: 1000, MaxHeight: 300},
+			size:    image.Pt(50, 100),
+			expect:  3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.expect, tt.options.resizeScale(tt.size), 1e-9)
+		})
+	}
+}
+
+func Test_resizeImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 30, 7))
+
+	t.Run("min long edge capped by limits", func(t *testing.T) {
+		options := resizeOptions{MinLongEdge: 1000, Resample: "nearest"}
+		got, scale, err := resizeImage(img, options.withinLimits(imageLimits{MaxPixels: 1000}))
+		require.NoError(t, err)
+		size := got.Bounds().Size()
+		assert.LessOrEqual(t, size.X*size.Y, 1000)
+		assert.Greater(t, scale, 1.0)
+	})
+
+	t.Run("min long edge capped by dimension limits", func(t *testing.T) {
+		options := resizeOptions{MinLongEdge: 1000, Resample: "nearest"}
+		got, _, err := resizeImage(img, options.withinLimits(imageLimits{MaxWidth: 90, MaxHeight: 20}))
+		require.NoError(t, err)
+		size := got.Bounds().Size()
+		assert.LessOrEqual(t, size.X, 90)
+		assert.LessOrEqual(t, size.Y, 20)
+		assert.NoError(t, imageLimits{MaxWidth: 90, MaxHeight: 20}.check(size.X, size.Y))
+	})
+
+	t.Run("max pixels not exceeded by rounding", func(t *testing.T) {
+		got, _, err := resizeImage(img, resizeOptions{MaxPixels: 100, Resample: "nearest"})
+		require.NoError(t, err)
+		size := got.Bounds().Size()
+		assert.LessOrEqual(t, size.X*size.Y, 100)
+	})
+}
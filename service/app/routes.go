@@ -7,64 +7,267 @@ import (
 	"github.com/lefinal/image-to-ma3-scribble/web"
 	"github.com/lefinal/meh"
 	"go.uber.org/zap"
+	"math"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 )
 
-func (app *App) handlePNGToMA3Scribble(previewOnly bool) web.HandlerFunc {
-	return func(logger *zap.Logger, c *gin.Context) error {
-		// Parse query params.
-		preprocessOptions, err := preprocessPNGOptionsFromQueryParams(c)
-		if err != nil {
-			return meh.Wrap(err, "parse preprocess options from query params", nil)
-		}
-		traceConfig, err := traceConfigFromQueryParams(c)
-		if err != nil {
-			return meh.Wrap(err, "parse trace request config from query params", nil)
+// headerBlackLevel is the response header that holds the black level that was
+// used for tracing. This is useful with automatic black level detection.
+const headerBlackLevel = "X-Black-Level"
+
+// preparedTrace holds everything that is needed for tracing an uploaded image.
+type preparedTrace struct {
+	TraceConfig       TraceConfig
+	MA3ScribbleConfig MA3ScribbleConfig
+	Preprocessed      preprocessResult
+	// Layers are the bitmaps to trace.
+	Layers []thresholdedColorLayer
+}
+
+// prepareTrace parses the query params, preprocesses the uploaded image and
+// splits it into color layers, which are thresholded for tracing.
+func (app *App) prepareTrace(logger *zap.Logger, c *gin.Context) (preparedTrace, error) {
+	// Parse query params.
+	preprocessPipeline, err := preprocessPipelineFromQueryParams(c)
+	if err != nil {
+		return preparedTrace{}, meh.Wrap(err, "parse preprocess pipeline from query params", nil)
+	}
+	traceConfig, err := traceConfigFromQueryParams(c)
+	if err != nil {
+		return preparedTrace{}, meh.Wrap(err, "parse trace request config from query params", nil)
+	}
+	traceConfig.Backend, err = app.resolveTraceBackend(traceConfig)
+	if err != nil {
+		return preparedTrace{}, meh.Wrap(err, "resolve trace backend", nil)
+	}
+	ma3ScribbleConfig, err := ma3ScribbleConfigFromQueryParams(c)
+	if err != nil {
+		return preparedTrace{}, meh.Wrap(err, "parse ma3 scribble config from query params", nil)
+	}
+	quantizeOptions, err := quantizeOptionsFromQueryParams(c)
+	if err != nil {
+		return preparedTrace{}, meh.Wrap(err, "parse quantize options from query params", nil)
+	}
+
+	// Preprocess.
+	preprocessed, err := app.preprocessImage(logger.Named("preprocess"), c.Request.Body, preprocessPipeline, traceConfig)
+	if err != nil {
+		return preparedTrace{}, meh.Wrap(err, "preprocess image", nil)
+	}
+	// Turd size refers to the original resolution. As it describes an area, it
+	// scales quadratically.
+	traceConfig.TurdSize = int(math.Round(float64(traceConfig.TurdSize) * preprocessed.Scale * preprocessed.Scale))
+	traceConfig.CenterlineMinLength *= preprocessed.Scale
+	// Any black level between black and white yields the same result for bilevel
+	// images. If preprocessing already thresholded with the requested black level,
+	// keep reporting the one that was used.
+	if preprocessed.Bilevel {
+		traceConfig.BlackLevel = .5
+		if preprocessed.BlackLevel != nil {
+			traceConfig.BlackLevel = *preprocessed.BlackLevel
 		}
-		ma3ScribbleConfig, err := ma3ScribbleConfigFromQueryParams(c)
-		if err != nil {
-			return meh.Wrap(err, "parse ma3 scribble config from query params", nil)
+		traceConfig.AutoBlackLevel = false
+	}
+
+	// Split into layers.
+	var layers []colorLayer
+	switch quantizeOptions.Mode {
+	case colorModeQuantize:
+		layers = splitColorLayers(preprocessed.Image, quantizeOptions)
+		logger.Debug("split color layers", zap.Int("layer_count", len(layers)))
+		if len(layers) == 0 {
+			return preparedTrace{}, meh.NewBadInputErr("image has no color layers besides the background", nil)
 		}
+		// Layers are bilevel.
+		traceConfig.BlackLevel = .5
+		traceConfig.AutoBlackLevel = false
+	default:
+		layers = []colorLayer{{
+			Color: ma3ScribbleConfig.StrokeColor,
+			Image: preprocessed.Image,
+		}}
+	}
 
-		// Preprocess.
-		var preprocessedPNG bytes.Buffer
-		err = app.preprocessPNG(logger.Named("preprocess"), c.Request.Body, &preprocessedPNG, preprocessOptions)
+	return preparedTrace{
+		TraceConfig:       traceConfig,
+		MA3ScribbleConfig: ma3ScribbleConfig,
+		Preprocessed:      preprocessed,
+		Layers:            traceConfig.thresholdColorLayers(logger.Named("threshold"), layers),
+	}, nil
+}
+
+func (app *App) handleImageToMA3Scribble(previewOnly bool) web.HandlerFunc {
+	return func(logger *zap.Logger, c *gin.Context) error {
+		prepared, err := app.prepareTrace(logger, c)
 		if err != nil {
-			return meh.Wrap(err, "preprocess png", nil)
+			return meh.Wrap(err, "prepare trace", nil)
 		}
+		traceConfig := prepared.TraceConfig
+		ma3ScribbleConfig := prepared.MA3ScribbleConfig
+		layers := prepared.Layers
 
 		// Trace.
-		var tracedSVG bytes.Buffer
-		err = app.traceWithPotrace(c.Request.Context(), logger.Named("trace"), traceConfig, &preprocessedPNG, &tracedSVG)
+		tracedLayers, err := app.traceColorLayers(c.Request.Context(), logger.Named("trace"), traceConfig, layers)
 		if err != nil {
-			return meh.Wrap(err, "trace svg with potrace", nil)
+			return meh.Wrap(err, "trace color layers", nil)
 		}
+		blackLevel := traceConfig.BlackLevel
+		if len(tracedLayers) > 0 {
+			blackLevel = tracedLayers[0].BlackLevel
+		}
+		c.Header(headerBlackLevel, strconv.FormatFloat(blackLevel, 'f', 4, 64))
 
 		if previewOnly {
-			// Make some sneaky changes to simulate stroke settings.
-			tracedSVGStr := tracedSVG.String()
-			svgStrokeColor := rgbaToHex(ma3ScribbleConfig.StrokeColor)
-			replacement := fmt.Sprintf(`fill="transparent" stroke="%s" stroke-width="50"`, svgStrokeColor)
-			tracedSVGStr = strings.ReplaceAll(tracedSVGStr, `fill="#000000" stroke="none"`, replacement)
-			tracedSVGStr = strings.ReplaceAll(tracedSVGStr, `fill="#ffffff" stroke="none"`, replacement)
-
-			// We just keep it lol.
-			_ = os.WriteFile("TMPx.svg", tracedSVG.Bytes(), 0644)
-
-			c.Data(http.StatusOK, "image/xml+svg", []byte(tracedSVGStr))
+			c.Data(http.StatusOK, "image/xml+svg", svgPreview(tracedLayers))
 			return nil
 		}
 
 		// Encode to MA3 scribble.
+		geometries := make([]tracedGeometry, 0, len(tracedLayers))
+		for _, layer := range tracedLayers {
+			geometries = append(geometries, layer.Geometry)
+		}
+		frame := scribbleFrame{}
+		if len(geometries) > 0 {
+			frame = geometries[0].viewBoxFrame()
+		}
+		if ma3ScribbleConfig.FitPathBounds {
+			frame = fitPathBoundsFrame(geometries, frame)
+		}
+		ma3Paths := make([]string, 0)
+		for _, layer := range tracedLayers {
+			ma3Paths = append(ma3Paths, curvesToMA3ScribblePaths(ma3ScribbleConfig, layer.Color, layer.Geometry.curves(), frame)...)
+		}
 		var ma3ScribbleXML bytes.Buffer
-		err = app.encodeSVGToMA3Scribble(logger.Named("encode-ma3"), ma3ScribbleConfig, &tracedSVG, &ma3ScribbleXML)
+		err = encodeMA3Scribble(ma3ScribbleConfig, ma3Paths, &ma3ScribbleXML)
 		if err != nil {
-			return meh.Wrap(err, "encode svg to ma3 scribble", nil)
+			return meh.NewInternalErrFromErr(err, "encode ma3 scribble", nil)
 		}
 
 		c.Data(http.StatusOK, "application/xml", ma3ScribbleXML.Bytes())
 		return nil
 	}
 }
+
+// fitPathBoundsFrame returns the union of the path bounds of all given
+// geometries. If there are no paths at all, the fallback is returned.
+func fitPathBoundsFrame(geometries []tracedGeometry, fallback scribbleFrame) scribbleFrame {
+	frame := scribbleFrame{}
+	found := false
+	for _, geometry := range geometries {
+		bounds, ok := geometry.pathBounds()
+		if !ok {
+			continue
+		}
+		if !found {
+			frame = bounds
+			found = true
+			continue
+		}
+		frame = frame.union(bounds)
+	}
+	if !found {
+		return fallback
+	}
+	return frame
+}
+
+// svgPreview renders the paths of all given layers as SVG. Paths are stroked in
+// the layer color to simulate the scribble.
+func svgPreview(layers []tracedColorLayer) []byte {
+	width, height := 0, 0
+	if len(layers) > 0 {
+		width, height = layers[0].Geometry.Width, layers[0].Geometry.Height
+	}
+	var svg strings.Builder
+	svg.WriteString(`<?xml version="1.0" standalone="no"?>` + "\n")
+	_, _ = fmt.Fprintf(&svg, `<svg version="1.0" xmlns="http://www.w3.org/2000/svg"`+"\n"+
+		` width="%[1]dpt" height="%[2]dpt" viewBox="0 0 %[1]d %[2]d"`+"\n"+
+		` preserveAspectRatio="xMidYMid meet">`+"\n", width, height)
+	// Round to tenths of pixels like potrace does, which is plenty for previews.
+	format := func(p point) string {
+		return strconv.FormatFloat(math.Round(p.X*10)/10, 'f', -1, 64) + " " +
+			strconv.FormatFloat(math.Round(p.Y*10)/10, 'f', -1, 64)
+	}
+	for _, layer := range layers {
+		_, _ = fmt.Fprintf(&svg, `<g fill="transparent" stroke="%s" stroke-width="5">`+"\n", rgbaToHex(layer.Color))
+		for _, path := range layer.Geometry.Paths {
+			if len(path.Curves) == 0 {
+				continue
+			}
+			d := []string{"M" + format(path.Curves[0].P0)}
+			for _, curve := range path.Curves {
+				d = append(d, "C"+format(curve.P1)+" "+format(curve.P2)+" "+format(curve.P3))
+			}
+			if path.Closed {
+				d = append(d, "z")
+			}
+			_, _ = fmt.Fprintf(&svg, `<path d="%s"/>`+"\n", strings.Join(d, " "))
+		}
+		svg.WriteString("</g>\n")
+	}
+	svg.WriteString("</svg>\n")
+	return []byte(svg.String())
+}
+
+// readyResponse is the response for handleReady.
+type readyResponse struct {
+	// Reason is set if the App is not ready.
+	Reason        string   `json:"reason,omitempty"`
+	TraceBackends []string `json:"trace_backends"`
+}
+
+// handleReady reports readiness along with the available trace backends. Until
+// the self-test passed, it responds with 503 and the reason.
+func (app *App) handleReady(c *gin.Context) {
+	response := readyResponse{
+		Reason:        app.readiness(),
+		TraceBackends: app.traceBackends(),
+	}
+	if response.Reason != "" {
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// traceBackendCapabilities describes an available trace backend.
+type traceBackendCapabilities struct {
+	Name  string   `json:"name"`
+	Modes []string `json:"modes"`
+}
+
+// capabilitiesResponse is the response for handleCapabilities.
+type capabilitiesResponse struct {
+	// TraceBackends are the available trace backends in order of preference.
+	TraceBackends []traceBackendCapabilities `json:"trace_backends"`
+	// TraceColorModes are the values for the trace_color_mode query param.
+	TraceColorModes []string `json:"trace_color_modes"`
+	// TraceQuantizeMaxColors is the maximum for the trace_quantize_colors query
+	// param and the number of trace_quantize_palette colors.
+	TraceQuantizeMaxColors int      `json:"trace_quantize_max_colors"`
+	PreprocessSteps        []string `json:"preprocess_steps"`
+}
+
+// handleCapabilities responds with the options that are available in this
+// deployment.
+func (app *App) handleCapabilities() web.HandlerFunc {
+	return func(_ *zap.Logger, c *gin.Context) error {
+		response := capabilitiesResponse{
+			TraceBackends:          make([]traceBackendCapabilities, 0, len(app.tracers)),
+			TraceColorModes:        allowedColorModes,
+			TraceQuantizeMaxColors: maxQuantizeColors,
+			PreprocessSteps:        registeredPreprocessSteps(),
+		}
+		for _, backend := range app.traceBackends() {
+			response.TraceBackends = append(response.TraceBackends, traceBackendCapabilities{
+				Name:  backend,
+				Modes: app.tracers[backend].modes(),
+			})
+		}
+		c.JSON(http.StatusOK, response)
+		return nil
+	}
+}
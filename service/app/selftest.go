@@ -0,0 +1,90 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"github.com/lefinal/meh"
+	"github.com/lefinal/meh/mehlog"
+	"go.uber.org/zap"
+	"image/png"
+	"time"
+)
+
+// selfTestPNG is a small ring that is traced for checking readiness.
+//
+//go:embed selftest.png
+var selfTestPNG []byte
+
+// selfTestRetryInterval is the time to wait before retrying a failed self-test.
+const selfTestRetryInterval = 10 * time.Second
+
+// selfTest traces selfTestPNG with all available tracers in all of their
+// modes.
+func (app *App) selfTest(ctx context.Context, logger *zap.Logger) error {
+	img, err := png.Decode(bytes.NewReader(selfTestPNG))
+	if err != nil {
+		return meh.NewInternalErrFromErr(err, "decode self-test png", nil)
+	}
+	config := defaultTraceConfig()
+	// The default turd size refers to large photos.
+	config.TurdSize = 2
+	for _, backend := range app.traceBackends() {
+		t := app.tracers[backend]
+		for _, mode := range t.modes() {
+			config.Mode = mode
+			config.Backend = backend
+			details := meh.Details{"trace_backend": backend, "trace_mode": mode}
+			geometry, err := t.trace(ctx, logger, config, thresholdBitmap(img, config.BlackLevel, false))
+			if err != nil {
+				return meh.Wrap(err, "trace", details)
+			}
+			if len(geometry.Paths) == 0 {
+				return meh.NewInternalErr("traced no paths", details)
+			}
+		}
+	}
+	return nil
+}
+
+// runSelfTest runs selfTest until it passes or the context is done. The App is
+// not ready until then.
+func (app *App) runSelfTest(ctx context.Context) {
+	logger := app.logger.Named("self-test")
+	for {
+		start := time.Now()
+		err := app.selfTest(ctx, logger)
+		if err == nil {
+			logger.Info("self-test passed", zap.Duration("took", time.Since(start)))
+			app.setNotReadyReason("")
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		mehlog.Log(logger, meh.Wrap(err, "self-test", nil))
+		app.setNotReadyReason(fmt.Sprintf("self-test failed: %s", err.Error()))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(selfTestRetryInterval):
+		}
+	}
+}
+
+// setNotReadyReason sets the reason for the App not being ready. An empty
+// reason marks the App as ready.
+func (app *App) setNotReadyReason(reason string) {
+	app.readinessMutex.Lock()
+	defer app.readinessMutex.Unlock()
+	app.notReadyReason = reason
+}
+
+// readiness returns the reason for the App not being ready. It is empty if the
+// App is ready.
+func (app *App) readiness() string {
+	app.readinessMutex.RLock()
+	defer app.readinessMutex.RUnlock()
+	return app.notReadyReason
+}
@@ -0,0 +1,22 @@
+package app
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func Test_App_selfTest(t *testing.T) {
+	t.Run("builtin", func(t *testing.T) {
+		app := New(Config{Logger: zap.NewNop()})
+		assert.NotEmpty(t, app.readiness())
+		app.runSelfTest(context.Background())
+		assert.Empty(t, app.readiness())
+	})
+
+	t.Run("failing potrace", func(t *testing.T) {
+		app := New(Config{Logger: zap.NewNop(), PotraceFilename: fakePotrace(t, "exit 1")})
+		assert.Error(t, app.selfTest(context.Background(), zap.NewNop()))
+	})
+}
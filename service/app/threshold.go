@@ -0,0 +1,209 @@
+package app
+
+import (
+	"github.com/disintegration/gift"
+	"image"
+	"sync"
+)
+
+// potraceIntensityLevels is the number of distinct intensity values potrace
+// sees in an 8-bit RGB bitmap. Potrace compares the sum of the red, green and
+// blue channel against 3*255*blacklevel.
+const potraceIntensityLevels = 3*255 + 1
+
+// potraceIntensityHistogram builds a histogram of the intensity measure potrace
+// uses for thresholding, which is the sum of the 8-bit red, green and blue
+// channel values.
+func potraceIntensityHistogram(img image.Image) []int {
+	src := toRGBA(img)
+	width := src.Bounds().Dx()
+	histogram := make([]int, potraceIntensityLevels)
+	var mutex sync.Mutex
+	parallelRows(src.Bounds().Dy(), func(minY, maxY int) {
+		chunkHistogram := make([]int, potraceIntensityLevels)
+		for y := minY; y < maxY; y++ {
+			row := src.Pix[y*src.Stride : y*src.Stride+4*width]
+			for i := 0; i < len(row); i += 4 {
+				chunkHistogram[int(row[i])+int(row[i+1])+int(row[i+2])]++
+			}
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		for i, count := range chunkHistogram {
+			histogram[i] += count
+		}
+	})
+	return histogram
+}
+
+// otsuThreshold computes the threshold that maximizes the between-class
+// variance of the given histogram. Values less or equal than the returned index
+// belong to the lower class.
+func otsuThreshold(histogram []int) int {
+	total := 0
+	weightedSum := 0.0
+	for i, count := range histogram {
+		total += count
+		weightedSum += float64(i) * float64(count)
+	}
+	if total == 0 {
+		return len(histogram) / 2
+	}
+
+	bestThreshold := 0
+	bestVariance := -1.0
+	lowerCount := 0
+	lowerSum := 0.0
+	for i, count := range histogram {
+		lowerCount += count
+		if lowerCount == 0 {
+			continue
+		}
+		upperCount := total - lowerCount
+		if upperCount == 0 {
+			break
+		}
+		lowerSum += float64(i) * float64(count)
+		lowerMean := lowerSum / float64(lowerCount)
+		upperMean := (weightedSum - lowerSum) / float64(upperCount)
+		variance := float64(lowerCount) * float64(upperCount) * (lowerMean - upperMean) * (lowerMean - upperMean)
+		if variance > bestVariance {
+			bestVariance = variance
+			bestThreshold = i
+		}
+	}
+	return bestThreshold
+}
+
+// otsuBlackLevel computes the potrace black level for the given image using
+// Otsu's method.
+func otsuBlackLevel(img image.Image) float64 {
+	threshold := otsuThreshold(potraceIntensityHistogram(img))
+	// Add half a step for not being subject to rounding errors in potrace's
+	// comparison.
+	return (float64(threshold) + 0.5) / float64(potraceIntensityLevels-1)
+}
+
+const (
+	adaptiveThresholdMethodNone     = "none"
+	adaptiveThresholdMethodMean     = "mean"
+	adaptiveThresholdMethodGaussian = "gaussian"
+)
+
+var allowedAdaptiveThresholdMethods = []string{
+	adaptiveThresholdMethodNone,
+	adaptiveThresholdMethodMean,
+	adaptiveThresholdMethodGaussian,
+}
+
+// maxAdaptiveThresholdWindowSize limits the window size for adaptive
+// thresholding.
+const maxAdaptiveThresholdWindowSize = 1001
+
+// adaptiveThresholdOptions configures local thresholding where each pixel is
+// compared against the weighted mean of its neighborhood.
+type adaptiveThresholdOptions struct {
+	// Method is one of allowedAdaptiveThresholdMethods.
+	Method string
+	// WindowSize is the odd edge length of the neighborhood in pixels.
+	WindowSize int
+	// Offset is subtracted from the local mean. Intensity ranges from 0 to 255.
+	// Positive values suppress noise in even areas.
+	Offset float64
+}
+
+// grayIntensity converts the given image to grayscale using the same intensity
+// measure as potrace, which is the mean of the red, green and blue channel.
+func grayIntensity(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	gray := image.NewGray(image.Rect(0, 0, width, height))
+	if src, ok := img.(*image.Gray); ok {
+		for y := 0; y < height; y++ {
+			srcOffset := src.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			copy(gray.Pix[y*gray.Stride:y*gray.Stride+width], src.Pix[srcOffset:srcOffset+width])
+		}
+		return gray
+	}
+	src := toRGBA(img)
+	parallelRows(height, func(minY, maxY int) {
+		for y := minY; y < maxY; y++ {
+			srcRow := src.Pix[y*src.Stride : y*src.Stride+4*width]
+			grayRow := gray.Pix[y*gray.Stride : y*gray.Stride+width]
+			for x := range grayRow {
+				grayRow[x] = uint8((int(srcRow[4*x]) + int(srcRow[4*x+1]) + int(srcRow[4*x+2])) / 3)
+			}
+		}
+	})
+	return gray
+}
+
+// localMeanBox computes the mean of each pixel's square neighborhood with the
+// given window size using a summed-area table. The neighborhood is clipped at
+// the image borders.
+func localMeanBox(gray *image.Gray, windowSize int) []float64 {
+	bounds := gray.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	// Summed-area table with an additional leading row and column of zeros.
+	sums := make([]float64, (width+1)*(height+1))
+	for y := 0; y < height; y++ {
+		rowSum := 0.0
+		for x := 0; x < width; x++ {
+			rowSum += float64(gray.Pix[y*gray.Stride+x])
+			sums[(y+1)*(width+1)+x+1] = sums[y*(width+1)+x+1] + rowSum
+		}
+	}
+	radius := windowSize / 2
+	means := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		y0, y1 := max(0, y-radius), min(height, y+radius+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := max(0, x-radius), min(width, x+radius+1)
+			sum := sums[y1*(width+1)+x1] - sums[y0*(width+1)+x1] - sums[y1*(width+1)+x0] + sums[y0*(width+1)+x0]
+			means[y*width+x] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+	return means
+}
+
+// localMeanGaussian computes the Gaussian-weighted mean of each pixel's
+// neighborhood. The sigma is derived from the window size like in OpenCV.
+func localMeanGaussian(gray *image.Gray, windowSize int) []float64 {
+	sigma := 0.3*(float64(windowSize-1)*0.5-1) + 0.8
+	g := gift.New(gift.GaussianBlur(float32(sigma)))
+	blurred := image.NewGray(g.Bounds(gray.Bounds()))
+	g.Draw(blurred, gray)
+	width, height := blurred.Bounds().Dx(), blurred.Bounds().Dy()
+	means := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			means[y*width+x] = float64(blurred.Pix[y*blurred.Stride+x])
+		}
+	}
+	return means
+}
+
+// adaptiveThreshold converts the given image to a bilevel image where black
+// pixels are those that are darker than their local mean minus the offset.
+func adaptiveThreshold(img image.Image, options adaptiveThresholdOptions) *image.Gray {
+	gray := grayIntensity(img)
+	var means []float64
+	switch options.Method {
+	case adaptiveThresholdMethodGaussian:
+		means = localMeanGaussian(gray, options.WindowSize)
+	default:
+		means = localMeanBox(gray, options.WindowSize)
+	}
+	bounds := gray.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	bilevel := image.NewGray(bounds)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*gray.Stride + x
+			if float64(gray.Pix[i]) > means[y*width+x]-options.Offset {
+				bilevel.Pix[i] = 255
+			}
+		}
+	}
+	return bilevel
+}
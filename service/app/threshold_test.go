@@ -0,0 +1,89 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_otsuThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		histogram []int
+		expect    int
+	}{
+		{
+			name:      "empty",
+			histogram: make([]int, 10),
+			expect:    5,
+		},
+		{
+			name:      "bimodal",
+			histogram: []int{0, 5, 10, 5, 0, 0, 0, 5, 10, 5},
+			expect:    3,
+		},
+		{
+			name:      "two values",
+			histogram: []int{7, 0, 0, 0, 3},
+			expect:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expect, otsuThreshold(tt.histogram))
+		})
+	}
+}
+
+func Test_otsuBlackLevel(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			if x < 3 {
+				img.Set(x, y, color.RGBA{R: 40, G: 40, B: 40, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{R: 200, G: 200, B: 200, A: 255})
+			}
+		}
+	}
+	blackLevel := otsuBlackLevel(img)
+	// Dark pixels must be black and light ones white with potrace's comparison.
+	assert.LessOrEqual(t, float64(3*40), 3*255*blackLevel)
+	assert.Greater(t, float64(3*200), 3*255*blackLevel)
+}
+
+func Test_adaptiveThreshold(t *testing.T) {
+	// Horizontal gradient from dark to light with a darker vertical line on each
+	// side. A global threshold cannot separate both lines from the background.
+	img := image.NewGray(image.Rect(0, 0, 60, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 60; x++ {
+			v := uint8(60 + x*3)
+			if x == 10 || x == 50 {
+				v -= 40
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+
+	for _, method := range []string{adaptiveThresholdMethodMean, adaptiveThresholdMethodGaussian} {
+		t.Run(method, func(t *testing.T) {
+			bilevel := adaptiveThreshold(img, adaptiveThresholdOptions{
+				Method:     method,
+				WindowSize: 9,
+				Offset:     10,
+			})
+			for y := 0; y < 20; y++ {
+				for x := 0; x < 60; x++ {
+					if x == 10 || x == 50 {
+						assert.Equal(t, uint8(0), bilevel.GrayAt(x, y).Y, "line pixel (%d,%d) should be black", x, y)
+					} else if x > 1 && x < 58 {
+						assert.Equal(t, uint8(255), bilevel.GrayAt(x, y).Y, "background pixel (%d,%d) should be white", x, y)
+					}
+				}
+			}
+		})
+	}
+}
@@ -1,21 +1,16 @@
 package app
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/lefinal/meh"
-	"github.com/lefinal/meh/mehlog"
 	"go.uber.org/zap"
-	"golang.org/x/image/bmp"
-	"image/png"
-	"io"
-	"os"
-	"os/exec"
+	"golang.org/x/sync/errgroup"
+	"image"
+	"image/color"
 	"slices"
 	"strconv"
-	"time"
 )
 
 type TraceConfig struct {
@@ -24,21 +19,53 @@ type TraceConfig struct {
 	AlphaMax                   float64
 	CurveOptimizationTolerance float64
 	BlackLevel                 float64
-	Invert                     bool
+	// AutoBlackLevel determines the BlackLevel using Otsu's method.
+	AutoBlackLevel bool
+	Invert         bool
+	// Mode is either traceModeOutline or traceModeCenterline.
+	Mode string
+	// Backend is the name of the tracer out of allTraceBackends. If empty, the
+	// preferred available one supporting Mode is used.
+	Backend string
+	// CenterlineFitTolerance is the maximum distance in pixels between the
+	// skeleton and the fitted curves in centerline mode.
+	CenterlineFitTolerance float64
+	// CenterlineMinLength is the minimum length in pixels of skeleton branches
+	// with a loose end in centerline mode. Shorter ones are dropped.
+	CenterlineMinLength float64
 }
 
+const (
+	// traceModeOutline traces the outlines of shapes.
+	traceModeOutline = "outline"
+	// traceModeCenterline traces the skeleton of shapes, so that each drawn stroke
+	// becomes a single path.
+	traceModeCenterline = "centerline"
+)
+
+var allowedTraceModes = []string{traceModeOutline, traceModeCenterline}
+
 var allowedTraceTurnPolicies = []string{"black", "white", "right", "left", "minority", "majority", "random"}
 
-func traceConfigFromQueryParams(c *gin.Context) (TraceConfig, error) {
-	config := TraceConfig{
+// defaultTraceConfig returns the TraceConfig that is used if no query params
+// are set.
+func defaultTraceConfig() TraceConfig {
+	return TraceConfig{
 		TurnPolicy:                 "minority",
 		TurdSize:                   10_000,
 		AlphaMax:                   1,
 		CurveOptimizationTolerance: 0.2,
 		BlackLevel:                 .5,
+		AutoBlackLevel:             false,
 		Invert:                     false,
+		Mode:                       traceModeOutline,
+		CenterlineFitTolerance:     1,
+		CenterlineMinLength:        5,
 	}
+}
 
+func traceConfigFromQueryParams(c *gin.Context) (TraceConfig, error) {
+	config := defaultTraceConfig()
 	var err error
 	// Parse turn policy.
 	if v := c.Query("trace_turn_policy"); v != "" {
@@ -80,7 +107,9 @@ func traceConfigFromQueryParams(c *gin.Context) (TraceConfig, error) {
 	}
 
 	// Parse black level.
-	if v := c.Query("black_level"); v != "" {
+	if v := c.Query("black_level"); v == "auto" {
+		config.AutoBlackLevel = true
+	} else if v != "" {
 		config.BlackLevel, err = strconv.ParseFloat(v, 64)
 		if err != nil {
 			return TraceConfig{}, meh.NewBadInputErrFromErr(err, "parse black level", meh.Details{"was": v})
@@ -97,110 +126,125 @@ func traceConfigFromQueryParams(c *gin.Context) (TraceConfig, error) {
 		}
 	}
 
-	return config, nil
-}
-
-func (app *App) traceWithPotrace(ctx context.Context, logger *zap.Logger, config TraceConfig, r io.Reader, w io.Writer) error {
-	// Parse PNG from reader.
-	logger.Debug("read png")
-	imgPNG, err := png.Decode(r)
-	if err != nil {
-		return fmt.Errorf("decode png image: %w", err)
+	// Parse mode.
+	if v := c.Query("trace_mode"); v != "" {
+		if !slices.Contains(allowedTraceModes, v) {
+			return TraceConfig{}, meh.NewBadInputErr(fmt.Sprintf("unsupported trace mode: %s", v),
+				meh.Details{"allowed": allowedTraceModes})
+		}
+		config.Mode = v
 	}
 
-	// Convert to BMP.
-	logger.Debug("convert to bmp")
-	var imgBMP bytes.Buffer
-	err = bmp.Encode(&imgBMP, imgPNG)
-	if err != nil {
-		return fmt.Errorf("encode image to bmp: %w", err)
+	// Parse backend.
+	if v := c.Query("trace_backend"); v != "" {
+		if !slices.Contains(allTraceBackends, v) {
+			return TraceConfig{}, meh.NewBadInputErr(fmt.Sprintf("unsupported trace backend: %s", v),
+				meh.Details{"allowed": allTraceBackends})
+		}
+		config.Backend = v
 	}
 
-	logger.Debug("write to temporary file")
-	tmpInputFile, err := os.CreateTemp(os.TempDir(), "tmp-in.*.bmp")
-	if err != nil {
-		return meh.NewInternalErrFromErr(err, "create temporary input file", nil)
-	}
-	tmpInputFilename := tmpInputFile.Name()
-	defer func() {
-		err := os.Remove(tmpInputFilename)
+	// Parse centerline fit tolerance.
+	if v := c.Query("trace_centerline_tolerance"); v != "" {
+		config.CenterlineFitTolerance, err = strconv.ParseFloat(v, 64)
 		if err != nil {
-			mehlog.Log(logger, meh.NewInternalErrFromErr(err, "remove temporary input file", meh.Details{"tmp_input_filename": tmpInputFilename}))
+			return TraceConfig{}, meh.NewBadInputErrFromErr(err, "parse centerline tolerance", meh.Details{"was": v})
 		}
-	}()
-	defer func() { _ = tmpInputFile.Close() }()
-
-	// Write BMP to FS.
-	n, err := io.Copy(tmpInputFile, &imgBMP)
-	if err != nil {
-		return meh.NewInternalErrFromErr(err, "write bmp to temporary file", meh.Details{"filename": tmpInputFilename})
+		config.CenterlineFitTolerance = min(config.CenterlineFitTolerance, 100)
+		config.CenterlineFitTolerance = max(config.CenterlineFitTolerance, 0.1)
 	}
-	logger.Debug("temporary bmp file written", zap.Int64("bytes", n), zap.String("filename", tmpInputFilename))
 
-	// Create temporary output file.
-	logger.Debug("write to temporary file")
-	tmpOutputFile, err := os.CreateTemp(os.TempDir(), "tmp-out.*.svg")
-	if err != nil {
-		return meh.NewInternalErrFromErr(err, "create temporary output file", nil)
-	}
-	tmpOutputFilename := tmpOutputFile.Name()
-	defer func() {
-		err := os.Remove(tmpOutputFilename)
+	// Parse centerline min length.
+	if v := c.Query("trace_centerline_min_length"); v != "" {
+		config.CenterlineMinLength, err = strconv.ParseFloat(v, 64)
 		if err != nil {
-			mehlog.Log(logger, meh.NewInternalErrFromErr(err, "remove temporary output file", meh.Details{"tmp_output_filename": tmpInputFilename}))
+			return TraceConfig{}, meh.NewBadInputErrFromErr(err, "parse centerline min length", meh.Details{"was": v})
 		}
-	}()
-	_ = tmpOutputFile.Close()
-
-	// Run potrace.
-	cmd := exec.CommandContext(ctx, app.config.PotraceFilename)
-	cmd.Args = []string{
-		"--progress",
-		"--output=" + tmpOutputFilename,
-		"--backend=svg",
-		"--group",
-		"--flat",
-		fmt.Sprintf("--alphamax=%.10f", config.AlphaMax),
-		fmt.Sprintf("--turdsize=%d", config.TurdSize),
-		"--turnpolicy=" + config.TurnPolicy,
-		fmt.Sprintf("--blacklevel=%.10f", config.BlackLevel),
-		"--fill=#ffffff",
-	}
-	if config.CurveOptimizationTolerance == 0 {
-		cmd.Args = append(cmd.Args, "--longcurve")
-	} else {
-		cmd.Args = append(cmd.Args, fmt.Sprintf("--opttolerance=%.10f", config.CurveOptimizationTolerance))
-	}
-	if config.Invert {
-		cmd.Args = append(cmd.Args, "--invert")
-	}
-	cmd.Args = append(cmd.Args, tmpInputFilename)
-	start := time.Now()
-	logger.Debug("run tracing",
-		zap.String("command", app.config.PotraceFilename),
-		zap.Strings("args", cmd.Args),
-		zap.Time("start_at", start))
-	got, err := cmd.CombinedOutput()
-	logger.Debug("output", zap.ByteString("output", got))
-	if err != nil {
-		return meh.NewInternalErrFromErr(err, "run potrace", meh.Details{
-			"potrace_filename": app.config.PotraceFilename,
-			"args":             cmd.Args,
+		config.CenterlineMinLength = min(config.CenterlineMinLength, 100_000)
+		config.CenterlineMinLength = max(config.CenterlineMinLength, 0)
+	}
+
+	return config, nil
+}
+
+// blackLevelFor returns the black level to use for tracing the given image.
+// With AutoBlackLevel, it is determined using Otsu's method.
+func (config TraceConfig) blackLevelFor(logger *zap.Logger, img image.Image) float64 {
+	if !config.AutoBlackLevel {
+		return config.BlackLevel
+	}
+	blackLevel := otsuBlackLevel(img)
+	logger.Debug("determined black level", zap.Float64("black_level", blackLevel))
+	return blackLevel
+}
+
+// thresholdedColorLayer is a colorLayer as bitmap, exactly as it is handed to
+// the tracer.
+type thresholdedColorLayer struct {
+	Color color.RGBA
+	// BlackLevel is the black level that was actually used.
+	BlackLevel float64
+	Bitmap     *bitmap
+}
+
+// thresholdColorLayers thresholds all given layers with the black level from
+// the config. Invert is only applied to layers that are not masks.
+func (config TraceConfig) thresholdColorLayers(logger *zap.Logger, layers []colorLayer) []thresholdedColorLayer {
+	thresholded := make([]thresholdedColorLayer, 0, len(layers))
+	for i, layer := range layers {
+		layerLogger := logger.With(zap.Int("layer", i), zap.String("layer_color", rgbaToHex(layer.Color)))
+		blackLevel := config.blackLevelFor(layerLogger, layer.Image)
+		thresholded = append(thresholded, thresholdedColorLayer{
+			Color:      layer.Color,
+			BlackLevel: blackLevel,
+			Bitmap:     thresholdBitmap(layer.Image, blackLevel, config.Invert && !layer.IsMask),
 		})
 	}
-	logger.Debug("potrace done", zap.Duration("took", time.Since(start)))
+	return thresholded
+}
 
-	// Read file and write to writer.
-	tmpOutputFile, err = os.Open(tmpOutputFilename)
-	if err != nil {
-		return meh.NewInternalErrFromErr(err, "open temporary output file", meh.Details{"filename": tmpOutputFilename})
+// tracedColorLayer is the traced result of a thresholdedColorLayer.
+type tracedColorLayer struct {
+	Color    color.RGBA
+	Geometry tracedGeometry
+	// BlackLevel is the black level that was actually used.
+	BlackLevel float64
+}
+
+// traceColorLayers traces all given layers concurrently with the tracer of the
+// backend in the config. The backend must be resolved already. As the request
+// holds only a single slot in the traceQueue, the number of layers traced at
+// the same time is limited to its share of the CPUs. The bitmaps of the layers
+// may be modified.
+func (app *App) traceColorLayers(ctx context.Context, logger *zap.Logger, config TraceConfig, layers []thresholdedColorLayer) ([]tracedColorLayer, error) {
+	t, ok := app.tracers[config.Backend]
+	if !ok {
+		return nil, meh.NewInternalErr(fmt.Sprintf("unknown trace backend: %s", config.Backend), nil)
+	}
+	traced := make([]tracedColorLayer, len(layers))
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(app.traceQueue.layerConcurrency())
+	for i, layer := range layers {
+		eg.Go(func() error {
+			if egCtx.Err() != nil {
+				return meh.NewInternalErrFromErr(egCtx.Err(), "trace canceled", meh.Details{"layer": i})
+			}
+			layerLogger := logger.With(zap.Int("layer", i), zap.String("layer_color", rgbaToHex(layer.Color)))
+			geometry, err := t.trace(egCtx, layerLogger, config, layer.Bitmap)
+			if err != nil {
+				return meh.Wrap(err, "trace", meh.Details{"layer": i, "trace_backend": config.Backend})
+			}
+			traced[i] = tracedColorLayer{
+				Color:      layer.Color,
+				Geometry:   geometry,
+				BlackLevel: layer.BlackLevel,
+			}
+			return nil
+		})
 	}
-	defer func() { _ = tmpOutputFile.Close() }()
-	n, err = io.Copy(w, tmpOutputFile)
+	err := eg.Wait()
 	if err != nil {
-		return meh.NewInternalErrFromErr(err, "copy tmp output file contents to writer", meh.Details{"filename": tmpOutputFilename})
+		return nil, err
 	}
-	logger.Debug("finished reading output file", zap.Int64("bytes", n))
-
-	return nil
+	return traced, nil
 }
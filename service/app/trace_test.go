@@ -0,0 +1,26 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_TraceConfig_thresholdColorLayers(t *testing.T) {
+	// Black pixel on the left and white one on the right.
+	img := image.NewGray(image.Rect(0, 0, 2, 1))
+	img.Pix[1] = 255
+	config := defaultTraceConfig()
+	config.Invert = true
+
+	layers := config.thresholdColorLayers(zap.NewNop(), []colorLayer{
+		{Color: color.RGBA{A: 255}, Image: img},
+		{Color: color.RGBA{R: 255, A: 255}, Image: img, IsMask: true},
+	})
+	require.Len(t, layers, 2)
+	assert.Equal(t, []bool{false, true}, layers[0].Bitmap.pix, "image should be inverted")
+	assert.Equal(t, []bool{true, false}, layers[1].Bitmap.pix, "mask should not be inverted")
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/lefinal/meh"
+	"go.uber.org/zap"
+	"math"
+	"slices"
+)
+
+const (
+	// traceBackendPotrace traces outlines with the potrace binary. It is only
+	// available if Config.PotraceFilename is set.
+	traceBackendPotrace = "potrace"
+	// traceBackendBuiltin traces outlines and centerlines without any external
+	// dependencies.
+	traceBackendBuiltin = "builtin"
+)
+
+// allTraceBackends are all known trace backends in order of preference.
+var allTraceBackends = []string{traceBackendPotrace, traceBackendBuiltin}
+
+// tracer traces bitmaps into geometry. Implementations must be safe for
+// concurrent use.
+type tracer interface {
+	// modes returns the supported modes out of allowedTraceModes.
+	modes() []string
+	// trace traces the ink of the given bitmap. The bitmap may be modified.
+	trace(ctx context.Context, logger *zap.Logger, config TraceConfig, bm *bitmap) (tracedGeometry, error)
+}
+
+// newTracers returns the available tracers by their backend name.
+func newTracers(config Config) map[string]tracer {
+	tracers := map[string]tracer{
+		traceBackendBuiltin: builtinTracer{},
+	}
+	if config.PotraceFilename != "" {
+		tracers[traceBackendPotrace] = potraceTracer{filename: config.PotraceFilename}
+	}
+	return tracers
+}
+
+// traceBackends returns the names of the available tracers in order of
+// preference.
+func (app *App) traceBackends() []string {
+	backends := make([]string, 0, len(app.tracers))
+	for _, backend := range allTraceBackends {
+		if _, ok := app.tracers[backend]; ok {
+			backends = append(backends, backend)
+		}
+	}
+	return backends
+}
+
+// resolveTraceBackend returns the name of the backend to use for the given
+// config. If no backend is requested, the preferred one supporting the trace
+// mode is chosen.
+func (app *App) resolveTraceBackend(config TraceConfig) (string, error) {
+	if config.Backend == "" {
+		for _, backend := range app.traceBackends() {
+			if slices.Contains(app.tracers[backend].modes(), config.Mode) {
+				return backend, nil
+			}
+		}
+		return "", meh.NewBadInputErr(fmt.Sprintf("no trace backend supports trace mode: %s", config.Mode), nil)
+	}
+	t, ok := app.tracers[config.Backend]
+	if !ok {
+		return "", meh.NewBadInputErr(fmt.Sprintf("trace backend not available: %s", config.Backend),
+			meh.Details{"available": app.traceBackends()})
+	}
+	if !slices.Contains(t.modes(), config.Mode) {
+		return "", meh.NewBadInputErr(fmt.Sprintf("trace backend %s does not support trace mode: %s", config.Backend, config.Mode),
+			meh.Details{"supported": t.modes()})
+	}
+	return config.Backend, nil
+}
+
+// tracedPath is a sequence of connected cubic Bézier curves.
+type tracedPath struct {
+	Curves []cubicBezier
+	// Closed is true if the path ends where it starts, like outlines do.
+	Closed bool
+}
+
+// tracedGeometry is the result of tracing a bitmap. Coordinates are in pixels
+// with the y-axis pointing down.
+type tracedGeometry struct {
+	Width  int
+	Height int
+	Paths  []tracedPath
+}
+
+// lineBezier returns a straight line from a to b as cubic Bézier curve. Both
+// control points are in the middle of the line.
+func lineBezier(a, b point) cubicBezier {
+	middle := a.add(b).scale(.5)
+	return cubicBezier{P0: a, P1: middle, P2: middle, P3: b}
+}
+
+// curves returns the curves of all paths.
+func (geometry tracedGeometry) curves() []cubicBezier {
+	curves := make([]cubicBezier, 0)
+	for _, path := range geometry.Paths {
+		curves = append(curves, path.Curves...)
+	}
+	return curves
+}
+
+// viewBoxFrame returns the frame covering the whole traced bitmap.
+func (geometry tracedGeometry) viewBoxFrame() scribbleFrame {
+	return scribbleFrame{
+		MinX: 0,
+		MinY: 0,
+		MaxX: float64(geometry.Width),
+		MaxY: float64(geometry.Height),
+	}
+}
+
+// pathBounds returns the tight bounding box of all curves. If there are no
+// curves, false is returned.
+func (geometry tracedGeometry) pathBounds() (scribbleFrame, bool) {
+	bounds := scribbleFrame{
+		MinX: math.Inf(1),
+		MinY: math.Inf(1),
+		MaxX: math.Inf(-1),
+		MaxY: math.Inf(-1),
+	}
+	found := false
+	for _, curve := range geometry.curves() {
+		minPoint, maxPoint := curve.bounds()
+		bounds.MinX = min(bounds.MinX, minPoint.X)
+		bounds.MinY = min(bounds.MinY, minPoint.Y)
+		bounds.MaxX = max(bounds.MaxX, maxPoint.X)
+		bounds.MaxY = max(bounds.MaxY, maxPoint.Y)
+		found = true
+	}
+	return bounds, found
+}
+
+// builtinTracer traces with traceOutline and traceCenterline.
+type builtinTracer struct{}
+
+func (builtinTracer) modes() []string {
+	return []string{traceModeOutline, traceModeCenterline}
+}
+
+func (builtinTracer) trace(_ context.Context, logger *zap.Logger, config TraceConfig, bm *bitmap) (tracedGeometry, error) {
+	if config.Mode == traceModeCenterline {
+		return traceCenterline(logger, config, bm), nil
+	}
+	return traceOutline(logger, config, bm), nil
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"github.com/lefinal/meh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func Test_App_resolveTraceBackend(t *testing.T) {
+	builtinOnly := New(Config{Logger: zap.NewNop()})
+	withPotrace := New(Config{Logger: zap.NewNop(), PotraceFilename: "potrace"})
+	assert.Equal(t, []string{traceBackendBuiltin}, builtinOnly.traceBackends())
+	assert.Equal(t, []string{traceBackendPotrace, traceBackendBuiltin}, withPotrace.traceBackends())
+
+	tests := []struct {
+		name    string
+		app     *App
+		config  TraceConfig
+		want    string
+		wantErr bool
+	}{
+		{name: "default without potrace", app: builtinOnly, config: TraceConfig{Mode: traceModeOutline}, want: traceBackendBuiltin},
+		{name: "default with potrace", app: withPotrace, config: TraceConfig{Mode: traceModeOutline}, want: traceBackendPotrace},
+		{name: "default centerline", app: withPotrace, config: TraceConfig{Mode: traceModeCenterline}, want: traceBackendBuiltin},
+		{name: "requested", app: withPotrace, config: TraceConfig{Mode: traceModeOutline, Backend: traceBackendBuiltin}, want: traceBackendBuiltin},
+		{name: "not available", app: builtinOnly, config: TraceConfig{Mode: traceModeOutline, Backend: traceBackendPotrace}, wantErr: true},
+		{name: "mode not supported", app: withPotrace, config: TraceConfig{Mode: traceModeCenterline, Backend: traceBackendPotrace}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.app.resolveTraceBackend(tt.config)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Equal(t, meh.ErrBadInput, meh.ErrorCode(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_builtinTracer(t *testing.T) {
+	// Tracers may modify the bitmap, so each one gets a fresh bar.
+	bar := func() *bitmap {
+		bm := newBitmap(30, 20)
+		for y := 5; y < 15; y++ {
+			for x := 5; x < 25; x++ {
+				bm.pix[y*bm.width+x] = true
+			}
+		}
+		return bm
+	}
+	config := TraceConfig{
+		TurnPolicy:                 "minority",
+		AlphaMax:                   1,
+		CurveOptimizationTolerance: .2,
+		Mode:                       traceModeOutline,
+		CenterlineFitTolerance:     1,
+	}
+
+	t.Run("outline", func(t *testing.T) {
+		geometry, err := builtinTracer{}.trace(context.Background(), zap.NewNop(), config, bar())
+		require.NoError(t, err)
+		assert.Equal(t, scribbleFrame{MaxX: 30, MaxY: 20}, geometry.viewBoxFrame())
+		require.Len(t, geometry.Paths, 1)
+		assert.True(t, geometry.Paths[0].Closed)
+		bounds, ok := geometry.pathBounds()
+		require.True(t, ok)
+		assert.Equal(t, scribbleFrame{MinX: 5, MinY: 5, MaxX: 25, MaxY: 15}, bounds)
+	})
+
+	t.Run("centerline", func(t *testing.T) {
+		config := config
+		config.Mode = traceModeCenterline
+		geometry, err := builtinTracer{}.trace(context.Background(), zap.NewNop(), config, bar())
+		require.NoError(t, err)
+		require.Len(t, geometry.Paths, 1)
+		assert.False(t, geometry.Paths[0].Closed)
+		bounds, ok := geometry.pathBounds()
+		require.True(t, ok)
+		assert.InDelta(t, 10, bounds.MinY, 1)
+		assert.InDelta(t, 10, bounds.MaxY, 1)
+	})
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"fmt"
+	"github.com/disintegration/gift"
+	"github.com/lefinal/meh"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// cropValue is a single coordinate or length of a cropRect. It is either
+// absolute in pixels or relative to the image size in percent.
+type cropValue struct {
+	Value   float64
+	Percent bool
+}
+
+// resolve the cropValue for the given total length in pixels.
+func (v cropValue) resolve(total int) int {
+	if v.Percent {
+		return int(math.Round(v.Value / 100 * float64(total)))
+	}
+	return int(math.Round(v.Value))
+}
+
+// cropRect describes the rectangle to crop an image to.
+type cropRect struct {
+	X      cropValue
+	Y      cropValue
+	Width  cropValue
+	Height cropValue
+}
+
+// parseCropRect parses a crop rectangle in the format "x,y,width,height". Each
+// value may be suffixed with "%" for being relative to the image size.
+func parseCropRect(s string) (cropRect, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return cropRect{}, meh.NewBadInputErr("crop rect must have format x,y,width,height", meh.Details{"was": s})
+	}
+	values := make([]cropValue, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		var value cropValue
+		if strings.HasSuffix(part, "%") {
+			value.Percent = true
+			part = strings.TrimSuffix(part, "%")
+		}
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return cropRect{}, meh.NewBadInputErrFromErr(err, "parse crop rect value", meh.Details{"was": part})
+		}
+		if f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+			return cropRect{}, meh.NewBadInputErr(fmt.Sprintf("crop rect value must be non-negative: %s", part), nil)
+		}
+		value.Value = f
+		values = append(values, value)
+	}
+	return cropRect{
+		X:      values[0],
+		Y:      values[1],
+		Width:  values[2],
+		Height: values[3],
+	}, nil
+}
+
+// rectangle resolves the cropRect for the given image bounds. The result is
+// clipped to the bounds.
+func (rect cropRect) rectangle(bounds image.Rectangle) image.Rectangle {
+	size := bounds.Size()
+	x := rect.X.resolve(size.X)
+	y := rect.Y.resolve(size.Y)
+	r := image.Rect(x, y, x+rect.Width.resolve(size.X), y+rect.Height.resolve(size.Y))
+	return r.Add(bounds.Min).Intersect(bounds)
+}
+
+// cropImage crops the given image to the cropRect.
+func cropImage(img image.Image, rect cropRect) (image.Image, error) {
+	r := rect.rectangle(img.Bounds())
+	if r.Empty() {
+		return nil, meh.NewBadInputErr("crop rect does not overlap the image", meh.Details{
+			"image_bounds": img.Bounds().String(),
+		})
+	}
+	g := gift.New(gift.Crop(r))
+	dst := image.NewRGBA(g.Bounds(img.Bounds()))
+	g.Draw(dst, img)
+	return dst, nil
+}
+
+// transformOrientationOptions describes rotation and mirroring of an image.
+type transformOrientationOptions struct {
+	// RotateDegrees rotates the image clockwise.
+	RotateDegrees float64
+	// RotateFillColor is used for the areas that are not covered by the rotated
+	// image.
+	RotateFillColor color.RGBA
+	FlipHorizontal  bool
+	FlipVertical    bool
+}
+
+// transformOrientation rotates and flips the given image according to the
+// transformOrientationOptions. As rotating enlarges the image, an error is
+// returned if the result would exceed the given limits.
+func transformOrientation(img image.Image, options transformOrientationOptions, limits imageLimits) (image.Image, error) {
+	filters := make([]gift.Filter, 0)
+	rotate := math.Mod(options.RotateDegrees, 360)
+	if rotate < 0 {
+		rotate += 360
+	}
+	switch rotate {
+	case 0:
+		// No rotation.
+	case 90:
+		filters = append(filters, gift.Rotate270())
+	case 180:
+		filters = append(filters, gift.Rotate180())
+	case 270:
+		filters = append(filters, gift.Rotate90())
+	default:
+		// gift rotates counter-clockwise.
+		filters = append(filters, gift.Rotate(float32(-rotate), options.RotateFillColor, gift.CubicInterpolation))
+	}
+	if options.FlipHorizontal {
+		filters = append(filters, gift.FlipHorizontal())
+	}
+	if options.FlipVertical {
+		filters = append(filters, gift.FlipVertical())
+	}
+	if len(filters) == 0 {
+		return img, nil
+	}
+	g := gift.New(filters...)
+	bounds := g.Bounds(img.Bounds())
+	err := limits.check(bounds.Dx(), bounds.Dy())
+	if err != nil {
+		return nil, meh.Wrap(err, "check rotated image limits", nil)
+	}
+	dst := image.NewRGBA(bounds)
+	g.Draw(dst, img)
+	return dst, nil
+}
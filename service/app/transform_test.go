@@ -0,0 +1,92 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image"
+	"testing"
+)
+
+func Test_parseCropRect(t *testing.T) {
+	tests := []struct {
+		name       string
+		s          string
+		bounds     image.Rectangle
+		expectErr  bool
+		expectRect image.Rectangle
+	}{
+		{
+			name:       "pixels",
+			s:          "10,20,30,40",
+			bounds:     image.Rect(0, 0, 100, 100),
+			expectRect: image.Rect(10, 20, 40, 60),
+		},
+		{
+			name:       "percent",
+			s:          "10%,25%,50%,50%",
+			bounds:     image.Rect(0, 0, 200, 100),
+			expectRect: image.Rect(20, 25, 120, 75),
+		},
+		{
+			name:       "mixed and clipped",
+			s:          "50%, 0, 1000, 50%",
+			bounds:     image.Rect(0, 0, 200, 100),
+			expectRect: image.Rect(100, 0, 200, 50),
+		},
+		{
+			name:       "offset bounds",
+			s:          "0,0,10,10",
+			bounds:     image.Rect(5, 5, 100, 100),
+			expectRect: image.Rect(5, 5, 15, 15),
+		},
+		{
+			name:      "too few values",
+			s:         "1,2,3",
+			expectErr: true,
+		},
+		{
+			name:      "negative",
+			s:         "1,-2,3,4",
+			expectErr: true,
+		},
+		{
+			name:      "invalid number",
+			s:         "1,a,3,4",
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rect, err := parseCropRect(tt.s)
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectRect, rect.rectangle(tt.bounds))
+		})
+	}
+}
+
+func Test_transformOrientation(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	limits := imageLimits{MaxWidth: 200, MaxHeight: 200, MaxPixels: 10_000}
+
+	t.Run("flip", func(t *testing.T) {
+		got, err := transformOrientation(img, transformOrientationOptions{FlipHorizontal: true}, limits)
+		require.NoError(t, err)
+		assert.Equal(t, image.Pt(100, 50), got.Bounds().Size())
+	})
+
+	t.Run("rotate within limits", func(t *testing.T) {
+		got, err := transformOrientation(img, transformOrientationOptions{RotateDegrees: 90}, limits)
+		require.NoError(t, err)
+		assert.Equal(t, image.Pt(50, 100), got.Bounds().Size())
+	})
+
+	t.Run("rotate exceeding limits", func(t *testing.T) {
+		_, err := transformOrientation(img, transformOrientationOptions{RotateDegrees: 45}, limits)
+		assert.Error(t, err)
+	})
+}
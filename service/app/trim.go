@@ -0,0 +1,62 @@
+package app
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// maxAutoTrimPadding is the maximum padding in pixels for autoTrimOptions.
+const maxAutoTrimPadding = 1000
+
+// autoTrimOptions configures cropping an image to its content.
+type autoTrimOptions struct {
+	Enabled bool
+	// Tolerance is the maximum color distance from 0 to 1 of pixels to the
+	// background color for being considered background.
+	Tolerance float64
+	// Padding in pixels that is kept around the content. Areas outside the image
+	// are filled with the background color.
+	Padding int
+}
+
+// contentBounds returns the bounding box of all pixels that differ from the
+// given background color by more than the tolerance. If no pixel does, false is
+// returned.
+func contentBounds(img image.Image, background color.RGBA, tolerance float64) (image.Rectangle, bool) {
+	src := toRGBA(img)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	minX, minY, maxX, maxY := width, height, -1, -1
+	for y := 0; y < height; y++ {
+		row := src.Pix[y*src.Stride : y*src.Stride+4*width]
+		for x := 0; x < width; x++ {
+			c := color.RGBA{R: row[4*x], G: row[4*x+1], B: row[4*x+2], A: row[4*x+3]}
+			if colorDistance(c, background) <= tolerance {
+				continue
+			}
+			minX, minY = min(minX, x), min(minY, y)
+			maxX, maxY = max(maxX, x), max(maxY, y)
+		}
+	}
+	if maxX < 0 {
+		return image.Rectangle{}, false
+	}
+	// Relative to the original bounds.
+	return image.Rect(minX, minY, maxX+1, maxY+1).Add(img.Bounds().Min), true
+}
+
+// autoTrim crops the given image to the bounding box of its content. The
+// background color is the median color of the image border. If the image has no
+// content, it is returned unchanged.
+func autoTrim(img image.Image, options autoTrimOptions) image.Image {
+	background := medianBorderColor(img)
+	content, ok := contentBounds(img, background, options.Tolerance)
+	if !ok {
+		return img
+	}
+	content = content.Inset(-options.Padding)
+	dst := image.NewRGBA(image.Rect(0, 0, content.Dx(), content.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, content.Min, draw.Src)
+	return dst
+}
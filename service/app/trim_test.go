@@ -0,0 +1,61 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_autoTrim(t *testing.T) {
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	img.SetRGBA(5, 3, color.RGBA{A: 255})
+	img.SetRGBA(8, 6, color.RGBA{R: 200, G: 200, B: 200, A: 255})
+
+	t.Run("content bounds", func(t *testing.T) {
+		got, ok := contentBounds(img, white, 0.1)
+		assert.True(t, ok)
+		assert.Equal(t, image.Rect(5, 3, 9, 7), got)
+	})
+
+	t.Run("content bounds with tolerance", func(t *testing.T) {
+		got, ok := contentBounds(img, white, 0.3)
+		assert.True(t, ok)
+		assert.Equal(t, image.Rect(5, 3, 6, 4), got)
+	})
+
+	t.Run("no content", func(t *testing.T) {
+		_, ok := contentBounds(img, white, 1)
+		assert.False(t, ok)
+		got := autoTrim(img, autoTrimOptions{Enabled: true, Tolerance: 1})
+		assert.Equal(t, img.Bounds(), got.Bounds())
+	})
+
+	t.Run("trim", func(t *testing.T) {
+		got := autoTrim(img, autoTrimOptions{Enabled: true, Tolerance: 0.1})
+		assert.Equal(t, image.Rect(0, 0, 4, 4), got.Bounds())
+		assert.Equal(t, color.RGBA{A: 255}, color.RGBAModel.Convert(got.At(0, 0)))
+	})
+
+	t.Run("padding beyond image", func(t *testing.T) {
+		got := autoTrim(img, autoTrimOptions{Enabled: true, Tolerance: 0.1, Padding: 5})
+		assert.Equal(t, image.Rect(0, 0, 14, 14), got.Bounds())
+		assert.Equal(t, white, color.RGBAModel.Convert(got.At(0, 0)))
+		assert.Equal(t, color.RGBA{A: 255}, color.RGBAModel.Convert(got.At(5, 5)))
+	})
+}
+
+func Test_fitPathBoundsFrame(t *testing.T) {
+	fallback := scribbleFrame{MaxX: 100, MaxY: 50}
+	geometries := []tracedGeometry{
+		{Width: 100, Height: 50, Paths: []tracedPath{{Curves: []cubicBezier{{P0: point{X: 10, Y: 10}, P1: point{X: 12, Y: 8}, P2: point{X: 14, Y: 8}, P3: point{X: 20, Y: 10}}}}}},
+		{Width: 100, Height: 50},
+		{Width: 100, Height: 50, Paths: []tracedPath{{Curves: []cubicBezier{lineBezier(point{X: 30, Y: 20}, point{X: 30, Y: 40})}}}},
+	}
+	assert.Equal(t, scribbleFrame{MinX: 10, MinY: 8.5, MaxX: 30, MaxY: 40}, fitPathBoundsFrame(geometries, fallback))
+	assert.Equal(t, fallback, fitPathBoundsFrame(geometries[1:2], fallback))
+}
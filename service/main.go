@@ -12,14 +12,21 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
 )
 
 const (
-	envLogLevel          = "LOG_LEVEL"
-	envHTTPAPIListenAddr = "HTTP_API_LISTEN_ADDR"
-	envPotraceFilename   = "POTRACE_FILENAME"
+	envLogLevel            = "LOG_LEVEL"
+	envHTTPAPIListenAddr   = "HTTP_API_LISTEN_ADDR"
+	envPotraceFilename     = "POTRACE_FILENAME"
+	envMaxImageWidth       = "MAX_IMAGE_WIDTH"
+	envMaxImageHeight      = "MAX_IMAGE_HEIGHT"
+	envMaxImagePixels      = "MAX_IMAGE_PIXELS"
+	envMaxConcurrentTraces = "MAX_CONCURRENT_TRACES"
+	envMaxQueuedTraces     = "MAX_QUEUED_TRACES"
 )
 
 func run() error {
@@ -43,10 +50,27 @@ func run() error {
 	} else {
 		config.HTTPAPIListenAddr = v
 	}
-	if v := os.Getenv(envPotraceFilename); v == "" {
-		return fmt.Errorf("environment variable %s is not set", envPotraceFilename)
-	} else {
-		config.PotraceFilename = v
+	// Potrace is optional as outlines can also be traced with the built-in tracer.
+	config.PotraceFilename = os.Getenv(envPotraceFilename)
+	config.MaxImageWidth, err = intFromEnv(envMaxImageWidth, app.DefaultMaxImageWidth)
+	if err != nil {
+		return err
+	}
+	config.MaxImageHeight, err = intFromEnv(envMaxImageHeight, app.DefaultMaxImageHeight)
+	if err != nil {
+		return err
+	}
+	config.MaxImagePixels, err = intFromEnv(envMaxImagePixels, app.DefaultMaxImagePixels)
+	if err != nil {
+		return err
+	}
+	config.MaxConcurrentTraces, err = intFromEnv(envMaxConcurrentTraces, runtime.NumCPU())
+	if err != nil {
+		return err
+	}
+	config.MaxQueuedTraces, err = intFromEnv(envMaxQueuedTraces, app.DefaultMaxQueuedTraces)
+	if err != nil {
+		return err
 	}
 
 	// Run.
@@ -58,6 +82,23 @@ func run() error {
 	return nil
 }
 
+// intFromEnv parses the given environment variable as non-negative integer. If
+// it is not set, the fallback is returned.
+func intFromEnv(name string, fallback int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse environment variable %s: %w", name, err)
+	}
+	if i < 0 {
+		return 0, fmt.Errorf("environment variable %s must not be negative", name)
+	}
+	return i, nil
+}
+
 func main() {
 	errorLogger, err := logging.NewLogger(zapcore.ErrorLevel, logging.EncodingJSON)
 	if err != nil {
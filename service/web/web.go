@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/lefinal/image-to-ma3-scribble/logging"
 	"github.com/lefinal/image-to-ma3-scribble/validate"
 	"github.com/lefinal/meh"
 	"github.com/lefinal/meh/mehhttp"
@@ -13,8 +14,13 @@ import (
 	"time"
 )
 
+// ErrUnavailable is used for requests that cannot be handled at the moment, for
+// example, because of overload. Clients may retry later.
+const ErrUnavailable meh.Code = "unavailable"
+
 func init() {
 	gin.SetMode(gin.ReleaseMode)
+	logging.AddToDefaultLevelTranslator(ErrUnavailable, zap.WarnLevel)
 	mehhttp.SetHTTPStatusCodeMapping(func(code meh.Code) int {
 		//nolint:exhaustive
 		switch code {
@@ -26,6 +32,8 @@ func init() {
 			return http.StatusForbidden
 		case meh.ErrUnauthorized:
 			return http.StatusUnauthorized
+		case ErrUnavailable:
+			return http.StatusServiceUnavailable
 		default:
 			return http.StatusInternalServerError
 		}